@@ -0,0 +1,69 @@
+package authy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// AppStats are the usage counts of the app for a single month
+type AppStats struct {
+	Month              string `json:"month" xml:"month"`
+	Year               int    `json:"year" xml:"year"`
+	AuthsViaSMS        int64  `json:"auths_via_sms" xml:"auths_via_sms"`
+	AuthsViaPhoneCalls int64  `json:"auths_via_phone_calls" xml:"auths_via_phone_calls"`
+	UniqueUsers        int64  `json:"unique_users" xml:"unique_users"`
+	SMSCount           int64  `json:"sms_count" xml:"sms_count"`
+	CallsCount         int64  `json:"calls_count" xml:"calls_count"`
+	UsersCount         int64  `json:"users_count" xml:"users_count"`
+	AuthsCount         int64  `json:"auths_count" xml:"auths_count"`
+	APICallsCount      int64  `json:"api_calls_count" xml:"api_calls_count"`
+}
+
+// appStatsResponse is the data returned from the app stats endpoint
+type appStatsResponse struct {
+	Stats   []AppStats `json:"stats" xml:"stats>stat"`
+	Message string     `json:"message" xml:"message"`
+	Success bool       `json:"success" xml:"success"`
+}
+
+// GetAppStats gets the monthly usage statistics for the app
+func (c *Client) GetAppStats() ([]AppStats, error) {
+	return c.GetAppStatsWithContext(context.Background())
+}
+
+// GetAppStatsWithContext is like GetAppStats but uses the provided context
+func (c *Client) GetAppStatsWithContext(ctx context.Context) ([]AppStats, error) {
+	resource := new(appStatsResponse)
+	err := c.GetWithContext(ctx, "app/stats", resource)
+	if err != nil {
+		return nil, err
+	}
+
+	if !resource.Success {
+		return nil, fmt.Errorf("AUTHY: app stats not successful %v", resource.Message)
+	}
+
+	return resource.Stats, nil
+}
+
+// Ping checks authy is reachable and accepts the API key by fetching the app
+// details. The error wraps ErrNetwork when authy couldn't be reached and an
+// *APIError when the request was rejected
+func (c *Client) Ping(ctx context.Context) error {
+	// skip the app info cache so authy is always reached
+	_, err := c.fetchAppInfo(ctx)
+	if err == nil {
+		return nil
+	}
+
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden):
+		return fmt.Errorf("AUTHY: ping failed, api key was rejected: %w", err)
+	case errors.Is(err, ErrNetwork):
+		return fmt.Errorf("AUTHY: ping failed, authy is unreachable: %w", err)
+	}
+	return fmt.Errorf("AUTHY: ping failed: %w", err)
+}
@@ -0,0 +1,110 @@
+package authy
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestGetAppDetails(t *testing.T) {
+	setup()
+	defer teardown()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/app/details",
+		httpmock.NewStringResponder(200, `
+			{
+				"app": {
+					"name": "Test App",
+					"plan": "pay_as_you_go",
+					"sms_enabled": true,
+					"phone_calls_enabled": false,
+					"app_id": 3,
+					"onetouch_enabled": "true"
+				},
+				"success": true
+			}`))
+
+	info, err := client.GetAppDetails()
+	if err != nil {
+		t.Fatalf("GetAppDetails err = %v, expected nil", err)
+	}
+	expected := AppInfo{
+		Name:            "Test App",
+		Plan:            "pay_as_you_go",
+		SmsEnabled:      true,
+		AppID:           3,
+		OnetouchEnabled: true,
+	}
+	if *info != expected {
+		t.Errorf("GetAppDetails = %+v, expected %+v", info, expected)
+	}
+}
+
+func TestGetAppStats(t *testing.T) {
+	setup()
+	defer teardown()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/app/stats",
+		httpmock.NewStringResponder(200, `
+			{
+				"message": "Monthly statistics.",
+				"stats": [
+					{"month": "June", "year": 2019, "auths_via_sms": 20, "auths_via_phone_calls": 3, "unique_users": 12, "api_calls_count": 80},
+					{"month": "July", "year": 2019, "auths_via_sms": 31, "auths_via_phone_calls": 0, "unique_users": 17, "api_calls_count": 95}
+				],
+				"success": true
+			}`))
+
+	stats, err := client.GetAppStats()
+	if err != nil {
+		t.Fatalf("GetAppStats err = %v, expected nil", err)
+	}
+
+	expected := []AppStats{
+		{Month: "June", Year: 2019, AuthsViaSMS: 20, AuthsViaPhoneCalls: 3, UniqueUsers: 12, APICallsCount: 80},
+		{Month: "July", Year: 2019, AuthsViaSMS: 31, UniqueUsers: 17, APICallsCount: 95},
+	}
+	if len(stats) != len(expected) {
+		t.Fatalf("GetAppStats returned %d months, expected %d", len(stats), len(expected))
+	}
+	for i := range expected {
+		if stats[i] != expected[i] {
+			t.Errorf("GetAppStats[%d] = %+v, expected %+v", i, stats[i], expected[i])
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	setup()
+	defer teardown()
+
+	url := "https://api.authy.com/protected/json/app/details"
+	cases := []struct {
+		responder httpmock.Responder
+		kind      error
+		message   string
+	}{
+		{httpmock.NewStringResponder(200, `{"app": {"name": "Acme"}, "success": true}`), nil, ""},
+		{httpmock.NewStringResponder(401, `{"message": "Invalid API key", "success": false}`), ErrAPIResponse, "api key was rejected"},
+		{httpmock.NewErrorResponder(errors.New("dial tcp: no such host")), ErrNetwork, "authy is unreachable"},
+		{httpmock.NewStringResponder(503, ``), ErrAPIResponse, "ping failed"},
+	}
+
+	for _, c := range cases {
+		httpmock.RegisterResponder("GET", url, c.responder)
+
+		err := client.Ping(context.Background())
+		if c.kind == nil {
+			if err != nil {
+				t.Errorf("Ping err = %v, expected nil", err)
+			}
+			continue
+		}
+		if !errors.Is(err, c.kind) || !strings.Contains(err.Error(), c.message) {
+			t.Errorf("Ping err = %v, expected %v containing %q", err, c.kind, c.message)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package authy
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// appInfoCache holds the response of the app details endpoint
+type appInfoCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	info    *ResponseMessage
+	expires time.Time
+}
+
+// WithAppInfoCache makes GetAppInfo return the app details from the last
+// request for ttl rather than calling authy each time, as they rarely
+// change. RefreshAppInfo always makes a request and updates the cache
+func WithAppInfoCache(ttl time.Duration) Option {
+	return func(c *Client) {
+		if ttl <= 0 {
+			c.appInfo = nil
+			return
+		}
+		c.appInfo = &appInfoCache{ttl: ttl}
+	}
+}
+
+// get returns a copy of the cached info so callers can't modify the cache
+func (ac *appInfoCache) get() (*ResponseMessage, bool) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	if ac.info == nil || time.Now().After(ac.expires) {
+		return nil, false
+	}
+	info := *ac.info
+	return &info, true
+}
+
+func (ac *appInfoCache) put(info *ResponseMessage) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	cached := *info
+	ac.info = &cached
+	ac.expires = time.Now().Add(ac.ttl)
+}
+
+func (ac *appInfoCache) clear() {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	ac.info = nil
+}
+
+// RefreshAppInfo gets the app info from authy, updating the cache set up by
+// WithAppInfoCache. The cache is left alone when ctx has a key from
+// ContextWithAPIKey, as the info is for another app
+func (c *Client) RefreshAppInfo(ctx context.Context) (*ResponseMessage, error) {
+	info, err := c.fetchAppInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ctx.Value(apiKeyKey{}).(string); ok {
+		return info, nil
+	}
+	if c.appInfo != nil {
+		c.appInfo.put(info)
+	}
+	return info, nil
+}
@@ -0,0 +1,61 @@
+package authy
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestWithAppInfoCache(t *testing.T) {
+	cc, _ := NewClient(App{ApiSecret: "verysecret"}, WithAppInfoCache(time.Minute))
+	httpmock.ActivateNonDefault(cc.Client)
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/app/details",
+		httpmock.NewStringResponder(200, `{"app": {"name": "Test App"}, "success": true}`))
+
+	for i := 0; i < 3; i++ {
+		msg, err := cc.GetAppInfo()
+		if err != nil {
+			t.Fatalf("GetAppInfo err = %v, expected nil", err)
+		}
+		if msg.App.Name != "Test App" {
+			t.Errorf("GetAppInfo App.Name = %q, expected %q", msg.App.Name, "Test App")
+		}
+		msg.App.Name = "modified"
+	}
+	if n := httpmock.GetTotalCallCount(); n != 1 {
+		t.Errorf("GetAppInfo made %d requests, expected 1", n)
+	}
+
+	if _, err := cc.RefreshAppInfo(context.Background()); err != nil {
+		t.Fatalf("RefreshAppInfo err = %v, expected nil", err)
+	}
+	cc.GetAppInfo()
+	if err := cc.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping err = %v, expected nil", err)
+	}
+	if n := httpmock.GetTotalCallCount(); n != 3 {
+		t.Errorf("requests made = %d, expected 3 after refresh and ping", n)
+	}
+
+	// refreshing with another app's key doesn't cache its info
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/app/details",
+		func(req *http.Request) (*http.Response, error) {
+			name := "Test App"
+			if req.Header.Get("X-Authy-API-Key") == "brandkey" {
+				name = "Brand App"
+			}
+			return httpmock.NewStringResponse(200, `{"app": {"name": "`+name+`"}, "success": true}`), nil
+		})
+	msg, err := cc.RefreshAppInfo(ContextWithAPIKey(context.Background(), "brandkey"))
+	if err != nil || msg.App.Name != "Brand App" {
+		t.Fatalf("RefreshAppInfo = %+v, %v, expected Brand App", msg, err)
+	}
+	if msg, _ := cc.GetAppInfo(); msg.App.Name != "Test App" {
+		t.Errorf("GetAppInfo App.Name = %q after refreshing another app, expected %q", msg.App.Name, "Test App")
+	}
+}
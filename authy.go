@@ -3,15 +3,24 @@ package authy
 //Package for interacting with authy API for 2FA
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+
 	"github.com/google/go-querystring/query"
-	"log"
 )
 
 // Example usage
@@ -20,7 +29,10 @@ import (
 // }
 // func main() {
 // 	var authyUserID int64 = 34015850845
-// 	client := authy.NewClient(app)
+// 	client, err := authy.NewClient(app)
+// 	if err != nil {
+// 		log.Fatal(err)
+// 	}
 // 	msg, err := client.SendOTP(authyUserID)
 // 	fmt.Printf("%+v", msg)
 // 	if err != nil {
@@ -44,40 +56,208 @@ import (
 
 var baseUrl = "https://api.authy.com/protected/"
 
-// Client for interacting with the Authy API
+// Version is the version of this library, sent in the User-Agent header
+const Version = "0.1.0"
+
+// defaultUserAgent identifies this library in requests
+const defaultUserAgent = "authy-go-client/" + Version
+
+// Client for interacting with the Authy API. A Client is safe for concurrent
+// use by multiple goroutines and should be reused rather than created per
+// request. Its options must not be changed after NewClient returns
 type Client struct {
-	Client  *http.Client
-	app     App
-	baseURL *url.URL
+	Client    *http.Client
+	app       App
+	baseURL   *url.URL
+	timeout   time.Duration
+	retry     *retryPolicy
+	limiter   *limiter
+	logger    Logger
+	debug     *debugWriter
+	userAgent string
+
+	maxResponseBytes   int64
+	defaultCountryCode string
+	autoForceSMS       bool
+
+	createdUsers *userCache
+	appInfo      *appInfoCache
+	tlsConfig    *tls.Config
+	pool         *PoolConfig
+
+	rateLimitMu sync.Mutex
+	rateLimit   *RateLimitInfo
+
+	requestHooks  []RequestHook
+	responseHooks []ResponseHook
+
+	host   *url.URL // overrides the scheme and host of baseUrl
+	optErr error    // the first invalid option given to NewClient
 }
 
 type App struct {
 	ApiSecret string
-	ApiFormat string //xml or json defaults to json if not provided
+	ApiFormat string // xml or json, in any case, defaults to json if not provided - OneTouch only supports json
+}
+
+// String describes the app with the API secret redacted, so an App can be
+// logged safely
+func (a App) String() string {
+	return fmt.Sprintf("{ApiSecret:%s ApiFormat:%s}", RedactKey(a.ApiSecret), a.ApiFormat)
 }
 
-// NewClient returns a client to make requests to the Authy API
-func NewClient(a App) *Client {
-	urlWithFormat := baseUrl + "json/"
+// GoString is like String so %#v doesn't print the API secret either
+func (a App) GoString() string {
+	return fmt.Sprintf("authy.App{ApiSecret:%q, ApiFormat:%q}", RedactKey(a.ApiSecret), a.ApiFormat)
+}
+
+// Validate checks the app has an API secret that could be valid
+func (a App) Validate() error {
+	if a.ApiSecret == "" {
+		return fmt.Errorf("AUTHY: api secret not provided")
+	}
+	if strings.ContainsAny(a.ApiSecret, " \t\r\n") {
+		return fmt.Errorf("AUTHY: api secret contains whitespace")
+	}
+	switch strings.ToLower(a.ApiFormat) {
+	case "", "json", "xml":
+	default:
+		return fmt.Errorf("AUTHY: api format must be json or xml, not %q", a.ApiFormat)
+	}
+	return nil
+}
+
+// looksLikeAPIKey reports whether the key has the shape of an Authy API
+// key, 32 alphanumeric characters
+func looksLikeAPIKey(key string) bool {
+	if len(key) != 32 {
+		return false
+	}
+	for _, r := range key {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// NewClient returns a client to make requests to the Authy API, configured
+// by any options provided. An error is returned if the app or an option is invalid
+func NewClient(a App, opts ...Option) (*Client, error) {
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+	// the format is case insensitive, e.g. "XML"
+	a.ApiFormat = strings.ToLower(a.ApiFormat)
+
+	defaultClient := &http.Client{Timeout: time.Second * 20}
+	c := &Client{
+		Client:           defaultClient,
+		app:              a,
+		logger:           nopLogger{},
+		maxResponseBytes: defaultMaxResponseBytes,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.optErr != nil {
+		return nil, c.optErr
+	}
+
+	// the transport of a client given to WithHTTPClient is never replaced
+	if c.tlsConfig != nil || c.pool != nil {
+		if c.Client != defaultClient {
+			return nil, fmt.Errorf("AUTHY: WithTLSConfig and WithConnectionPool can't be used with WithHTTPClient, configure that client's transport instead")
+		}
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		if c.tlsConfig != nil {
+			t.TLSClientConfig = c.tlsConfig
+		}
+		if c.pool != nil {
+			c.pool.apply(t)
+		}
+		c.Client.Transport = t
+	}
+
+	if !looksLikeAPIKey(a.ApiSecret) {
+		c.logger.Printf("authy-go: api secret is not 32 alphanumeric characters, requests may be rejected")
+	}
+
+	root := baseUrl
+	if c.host != nil {
+		root = c.host.Scheme + "://" + c.host.Host + "/protected/"
+		if p := strings.TrimSuffix(c.host.EscapedPath(), "/"); p != "" {
+			root = c.host.Scheme + "://" + c.host.Host + p + "/"
+		}
+	}
+
+	urlWithFormat := root + "json/"
 	if a.ApiFormat == "xml" {
-		urlWithFormat = baseUrl + "xml/"
+		urlWithFormat = root + "xml/"
 	}
 
 	url, err := url.Parse(urlWithFormat)
 	if err != nil {
-		return nil
+		return nil, err
 	}
+	c.baseURL = url
 
-	return &Client{
-		Client:  &http.Client{Timeout: time.Second * 20},
-		app:     a,
-		baseURL: url,
+	// copy the http client so a client given to WithHTTPClient isn't modified
+	if c.timeout > 0 {
+		hc := *c.Client
+		hc.Timeout = c.timeout
+		c.Client = &hc
 	}
+	return c, nil
+}
+
+// String describes the client without its API secret
+func (c *Client) String() string {
+	return fmt.Sprintf("authy.Client{App:%v BaseURL:%v}", c.app, c.baseURL)
+}
+
+// GoString is like String so %#v doesn't print the API secret either
+func (c *Client) GoString() string {
+	return c.String()
+}
+
+// Close closes the idle connections of the http client and empties the
+// client's caches. The client doesn't start any goroutines that need
+// stopping, and can still be used after Close, opening new connections
+func (c *Client) Close() error {
+	c.Client.CloseIdleConnections()
+	if c.createdUsers != nil {
+		c.createdUsers.clear()
+	}
+	if c.appInfo != nil {
+		c.appInfo.clear()
+	}
+	return nil
+}
+
+// NewClientFromEnv returns a client for the app configured by the
+// AUTHY_API_SECRET and optional AUTHY_API_FORMAT environment variables
+func NewClientFromEnv(opts ...Option) (*Client, error) {
+	a := App{
+		ApiSecret: os.Getenv("AUTHY_API_SECRET"),
+		ApiFormat: os.Getenv("AUTHY_API_FORMAT"),
+	}
+	if a.ApiSecret == "" {
+		return nil, fmt.Errorf("AUTHY: AUTHY_API_SECRET is not set")
+	}
+
+	return NewClient(a, opts...)
 }
 
 // NewRequest creates a new request with the given method, path and marshals the given
 // body into url encoded data
 func (c *Client) NewRequest(method, relPath string, body interface{}) (*http.Request, error) {
+	return c.NewRequestWithContext(context.Background(), method, relPath, body)
+}
+
+// NewRequestWithContext is like NewRequest but the returned request carries
+// the provided context so it can be cancelled or given a deadline
+func (c *Client) NewRequestWithContext(ctx context.Context, method, relPath string, body interface{}) (*http.Request, error) {
 	rel, err := url.Parse(relPath)
 	if err != nil {
 		return nil, err
@@ -86,189 +266,1033 @@ func (c *Client) NewRequest(method, relPath string, body interface{}) (*http.Req
 	// Make the full url based on the relative path
 	u := c.baseURL.ResolveReference(rel)
 
-	var out url.Values
-	if body != nil {
-		out, err = query.Values(body)
-		if err != nil {
-			return nil, err
-		}
+	var out string
+	switch b := body.(type) {
+	case nil:
+	case formEncoder:
+		out, err = b.encodeForm()
+	default:
+		var v url.Values
+		v, err = query.Values(body)
+		out = v.Encode()
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	req, err := http.NewRequest(method, u.String(), strings.NewReader(out.Encode()))
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), strings.NewReader(out))
 	if err != nil {
 		return nil, err
 	}
 
+	// only the protected API honours the xml format
+	accept := "application/json"
+	if c.app.ApiFormat == "xml" && strings.HasPrefix(u.Path, c.baseURL.Path) {
+		accept = "application/xml"
+	}
+
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("User-Agent", "authy-go-client")
-	req.Header.Add("X-Authy-API-Key", c.app.ApiSecret)
+	req.Header.Add("Accept", accept)
+	c.authorize(req)
 	return req, nil
 }
 
+// apiKeyKey is the context key for the API key set by ContextWithAPIKey
+type apiKeyKey struct{}
+
+// ContextWithAPIKey returns a context that makes requests using it
+// authenticate with apiKey instead of the client's ApiSecret, so one client
+// can be shared between several authy apps. The created user cache isn't
+// used for these requests
+func ContextWithAPIKey(ctx context.Context, apiKey string) context.Context {
+	return context.WithValue(ctx, apiKeyKey{}, apiKey)
+}
+
+// authorize sets the User-Agent and API key headers on req if they're missing
+func (c *Client) authorize(req *http.Request) {
+	if req.Header.Get("User-Agent") == "" {
+		userAgent := defaultUserAgent
+		if c.userAgent != "" {
+			userAgent = c.userAgent + " " + defaultUserAgent
+		}
+		req.Header.Set("User-Agent", userAgent)
+	}
+	if req.Header.Get("X-Authy-API-Key") == "" {
+		apiKey := c.app.ApiSecret
+		if key, ok := req.Context().Value(apiKeyKey{}).(string); ok && key != "" {
+			apiKey = key
+		}
+		req.Header.Set("X-Authy-API-Key", apiKey)
+	}
+}
+
+// addOptions url encodes opts and adds them to the query string of the
+// provided path
+func addOptions(path string, opts interface{}) (string, error) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return path, err
+	}
+
+	qs, err := query.Values(opts)
+	if err != nil {
+		return path, err
+	}
+
+	// keep any parameters that are already part of the path
+	for k, v := range u.Query() {
+		qs[k] = append(qs[k], v...)
+	}
+
+	u.RawQuery = qs.Encode()
+	return u.String(), nil
+}
+
+// formEncoder is implemented by request bodies whose parameters must be
+// sent in a particular order, which url.Values doesn't keep
+type formEncoder interface {
+	encodeForm() (string, error)
+}
+
+// bracketParams encodes a map as key[name]=value parameters
+type bracketParams map[string]string
+
+func (p bracketParams) EncodeValues(key string, v *url.Values) error {
+	for k, val := range p {
+		v.Add(fmt.Sprintf("%s[%s]", key, k), val)
+	}
+	return nil
+}
+
 // GetAppInfo gets the app info for the provided API secret
 func (c *Client) GetAppInfo() (*ResponseMessage, error) {
-	info := new(ResponseMessage)
-	c.Get("app/details", info)
-	return info, nil
+	return c.GetAppInfoWithContext(context.Background())
 }
 
-// Get takes a relative path to which it makes a GET request and returns
-// reads the response data into the resource provided
-func (c *Client) Get(relPath string, resource interface{}) error {
-	req, err := c.NewRequest("GET", relPath, nil)
+// GetAppInfoWithContext is like GetAppInfo but uses the provided context
+func (c *Client) GetAppInfoWithContext(ctx context.Context) (*ResponseMessage, error) {
+	if c.appInfo == nil {
+		return c.fetchAppInfo(ctx)
+	}
+	if _, ok := ctx.Value(apiKeyKey{}).(string); ok {
+		return c.fetchAppInfo(ctx)
+	}
+	if info, ok := c.appInfo.get(); ok {
+		return info, nil
+	}
+	return c.RefreshAppInfo(ctx)
+}
+
+// GetAppDetails is like GetAppInfo but returns just the app's details, so
+// callers can check which channels are enabled
+func (c *Client) GetAppDetails() (*AppInfo, error) {
+	return c.GetAppDetailsWithContext(context.Background())
+}
+
+// GetAppDetailsWithContext is like GetAppDetails but uses the provided context
+func (c *Client) GetAppDetailsWithContext(ctx context.Context) (*AppInfo, error) {
+	msg, err := c.GetAppInfoWithContext(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return &msg.App, nil
+}
 
-	resp, err := c.Client.Do(req)
+// fetchAppInfo requests the app info, skipping the cache
+func (c *Client) fetchAppInfo(ctx context.Context) (*ResponseMessage, error) {
+	info := new(ResponseMessage)
+	err := c.GetWithContext(ctx, "app/details", info)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return info, nil
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
+// Get takes a relative path to which it makes a GET request and returns
+// reads the response data into the resource provided. The body is discarded
+// when resource is nil
+func (c *Client) Get(relPath string, resource interface{}) error {
+	return c.GetWithContext(context.Background(), relPath, resource)
+}
+
+// GetWithContext is like Get but the request is cancelled when the provided
+// context is done
+func (c *Client) GetWithContext(ctx context.Context, relPath string, resource interface{}) error {
+	req, err := c.NewRequestWithContext(ctx, "GET", relPath, nil)
 	if err != nil {
 		return err
 	}
 
-	json.Unmarshal(body, resource)
-	return nil
+	return c.do(req, resource)
 }
 
 // Post to Authy API based on path provided
 func (c *Client) Post(relPath string, body interface{}, resource interface{}) error {
-	req, err := c.NewRequest("POST", relPath, body)
+	return c.PostWithContext(context.Background(), relPath, body, resource)
+}
+
+// PostWithContext is like Post but the request is cancelled when the provided
+// context is done
+func (c *Client) PostWithContext(ctx context.Context, relPath string, body interface{}, resource interface{}) error {
+	req, err := c.NewRequestWithContext(ctx, "POST", relPath, body)
 	if err != nil {
 		return err
 	}
 
-	resp, err := c.Client.Do(req)
+	return c.do(req, resource)
+}
+
+// Do sends req with the API key and User-Agent headers, retries, rate
+// limiting and hooks used for every other request, and returns the raw
+// response for endpoints the client doesn't wrap. A relative url is resolved
+// against the client's base url. Like http.Client.Do, a non-2xx response
+// isn't an error. The body has already been read in full so the caller
+// should still close it but needn't worry about the connection
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	if !req.URL.IsAbs() {
+		req.URL = c.baseURL.ResolveReference(req.URL)
+		req.Host = req.URL.Host
+	}
+	c.authorize(req)
+
+	resp, body, err := c.send(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// responseRecorder is implemented by resources that want to keep the
+// status code, headers and raw body of the response they were decoded from
+type responseRecorder interface {
+	setResponse(resp *http.Response, body []byte)
+}
 
-	respBody, err := ioutil.ReadAll(resp.Body)
+// do sends the request and reads the response data into the resource provided
+func (c *Client) do(req *http.Request, resource interface{}) error {
+	resp, body, err := c.send(req)
 	if err != nil {
 		return err
 	}
 
-	json.Unmarshal(respBody, resource)
+	// record the response before decoding so callers can inspect it
+	// even when the body is malformed
+	if r, ok := resource.(responseRecorder); ok {
+		r.setResponse(resp, body)
+	}
+
+	// an empty body is reported by its status rather than as a failure to
+	// decode it
+	empty := len(bytes.TrimSpace(body)) == 0
+	format := responseFormat(resp, req)
+	var decodeErr error
+	if !empty && resource != nil {
+		decodeErr = decode(format, body, resource)
+	}
+	if decodeErr != nil {
+		c.logger.Printf("authy-go: error decoding %s response from %s: %v", format, redactPath(req.URL.Path), decodeErr)
+	}
+
+	// the status is more telling than a body that failed to decode,
+	// e.g. an html error page
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		if !empty {
+			decode(format, body, apiErr)
+		}
+		apiErr.RetryAfter, _ = retryAfter(resp)
+		return apiErr
+	}
+
+	// the caller doesn't want the body
+	if resource == nil {
+		return nil
+	}
+	if empty {
+		return &decodeError{
+			err:     ErrEmptyResponse,
+			context: fmt.Sprintf("status %d from %s", resp.StatusCode, redactPath(req.URL.Path)),
+		}
+	}
+	if decodeErr != nil {
+		return &decodeError{
+			err:     decodeErr,
+			context: fmt.Sprintf("%s response from %s %q", format, redactPath(req.URL.Path), truncate(body, 64)),
+		}
+	}
 	return nil
 }
 
-// the app data returned from the app endpoint
-type authyAppInfo struct {
-	Name              string `json:"name"`
-	Plan              string `json:"plan"`
-	SmsEnabled        bool   `json:"sms_enabled"`
-	PhoneCallsEnabled bool   `json:"phone_calls_enabled"`
-	AppID             int64  `json:"app_id"`
-	OnetouchEnabled   bool   `json:"onetouch_enabled"`
+// truncate returns at most the first n bytes of b
+func truncate(b []byte, n int) string {
+	if len(b) > n {
+		return string(b[:n]) + "..."
+	}
+	return string(b)
+}
+
+// responseFormat returns whether the response is xml or json based on its
+// content type, falling back to the format the request asked for
+func responseFormat(resp *http.Response, req *http.Request) string {
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = req.Header.Get("Accept")
+	}
+
+	if strings.Contains(contentType, "xml") {
+		return "xml"
+	}
+	return "json"
+}
+
+// decode unmarshals the body into v using the format provided
+func decode(format string, body []byte, v interface{}) error {
+	if format == "xml" {
+		return xml.Unmarshal(body, v)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// send makes the request, retrying it if the client has a retry policy,
+// and returns the final response along with its body
+func (c *Client) send(req *http.Request) (*http.Response, []byte, error) {
+	for attempt := 1; ; attempt++ {
+		if c.limiter != nil {
+			if err := c.limiter.wait(req.Context()); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		resp, body, err := c.roundTrip(req)
+		if err != nil {
+			// the url in the error can contain a verify token
+			if ue, ok := err.(*url.Error); ok {
+				err = &url.Error{Op: ue.Op, URL: redactURL(req.URL), Err: ue.Err}
+			}
+			return nil, nil, &networkError{err: err}
+		}
+
+		if c.retry == nil || !c.retry.shouldRetry(req.Method, resp.StatusCode, attempt) {
+			return resp, body, nil
+		}
+
+		delay := c.retry.delay(attempt, resp)
+		if delay > c.retry.max() {
+			// only a Retry-After can be over the max, the caller gets it
+			// on the *APIError rather than the request blocking
+			c.logger.Printf("authy-go: %s %s returned %d, not retrying as Retry-After %v is over the max delay", req.Method, redactPath(req.URL.Path), resp.StatusCode, delay)
+			return resp, body, nil
+		}
+		c.logger.Printf("authy-go: %s %s returned %d, retrying in %v (attempt %d)", req.Method, redactPath(req.URL.Path), resp.StatusCode, delay, attempt)
+		err = sleepContext(req.Context(), delay)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// the body of the previous attempt has been consumed
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+}
+
+// roundTrip makes a single attempt at the request, reading the whole body,
+// and calls the client's hooks around it
+func (c *Client) roundTrip(req *http.Request) (*http.Response, []byte, error) {
+	for _, hook := range c.requestHooks {
+		hook(req)
+	}
+
+	start := time.Now()
+	resp, err := c.Client.Do(req)
+	var body []byte
+	if err == nil {
+		body, err = readBody(resp.Body, c.maxResponseBytes)
+		resp.Body.Close()
+	}
+	elapsed := time.Since(start)
+	if resp != nil {
+		c.setRateLimit(parseRateLimit(resp))
+	}
+
+	if c.debug != nil {
+		c.debug.dump(req, resp, body, err, elapsed)
+	}
+
+	for _, hook := range c.responseHooks {
+		hook(resp, err, elapsed)
+	}
+	return resp, body, err
+}
+
+// readBody reads at most max bytes of body, returning an error if there's more
+func readBody(body io.Reader, max int64) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(body, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, fmt.Errorf("%w: over %d bytes", ErrResponseTooLarge, max)
+	}
+	return b, nil
+}
+
+// AppInfo is the app's details and which ways of sending tokens it has
+// enabled, as returned by the app endpoint
+type AppInfo struct {
+	Name              string `json:"name" xml:"name"`
+	Plan              string `json:"plan" xml:"plan"`
+	SmsEnabled        bool   `json:"sms_enabled" xml:"sms_enabled"`
+	PhoneCallsEnabled bool   `json:"phone_calls_enabled" xml:"phone_calls_enabled"`
+	AppID             int64  `json:"app_id" xml:"app_id"`
+	OnetouchEnabled   bool   `json:"onetouch_enabled" xml:"onetouch_enabled"`
 }
 
-// ResponseMessage is the wrapper for the data returned by the authy API
+// ResponseMessage is the wrapper for the data returned by the authy API.
+// Each endpoint only fills in the fields for its data, as noted below, and
+// Message and Success. Where there is a method with a typed result, e.g.
+// GetAppDetails, CreateUser, UserDetails, UserRegistrationStatus,
+// SendOTPWithResult and VerifyOTPToken, it is simpler to use
 type ResponseMessage struct {
-	App     authyAppInfo `json:"app"`
-	User    user         `json:"user"`
-	Status  status       `json:"status"`
-	Device  device       `json:"device"`
-	Token   string       `json:"token"`
-	Message string       `json:"message"`
-	Success bool         `json:"success"`
+	App     AppInfo `json:"app" xml:"app"`       // GetAppInfo
+	User    User    `json:"user" xml:"user"`     // CreateUser
+	Status  Status  `json:"status" xml:"status"` // UserStatus
+	Device  Device  `json:"device" xml:"device"` // verify, for the device the token came from
+	Token   string  `json:"token" xml:"token"`   // verify, "is valid" for a valid token
+	Message string  `json:"message" xml:"message"`
+	Success bool    `json:"success" xml:"success"`
+
+	// ErrorCode is authy's numeric code for why a request failed, e.g. 60033
+	// for an invalid phone number
+	ErrorCode string `json:"error_code" xml:"error_code"`
+
+	// Errors are the messages for each field authy rejected, e.g.
+	// "cellphone": "is invalid"
+	Errors map[string]string `json:"errors" xml:"-"`
+
+	// Cellphone is the masked number a SMS was sent to and Ignored is set
+	// when authy didn't send it, e.g. because the user has the app installed.
+	// They are returned by SendOTP
+	Cellphone string `json:"cellphone" xml:"cellphone"`
+	Ignored   bool   `json:"ignored" xml:"ignored"`
+
+	// Carrier and IsCellphone are returned when starting a phone verification
+	Carrier     string `json:"carrier" xml:"carrier"`
+	IsCellphone bool   `json:"is_cellphone" xml:"is_cellphone"`
+
+	// StatusCode, RawBody and RateLimit hold the HTTP status code, the
+	// unparsed body and the rate limit headers of the response the message
+	// was read from
+	StatusCode int           `json:"-" xml:"-"`
+	RawBody    []byte        `json:"-" xml:"-"`
+	RateLimit  RateLimitInfo `json:"-" xml:"-"`
 }
 
-// embedded user data in API response from user status enpoint
-type user struct {
-	ID int64 `json:"id"`
+// apiError returns the unsuccessful response as an *APIError so the
+// error code can be extracted with errors.As
+func (m *ResponseMessage) apiError() error {
+	return &APIError{
+		StatusCode: m.StatusCode,
+		Message:    m.Message,
+		Code:       m.ErrorCode,
+		Errors:     m.Errors,
+	}
+}
+
+func (m *ResponseMessage) setResponse(resp *http.Response, body []byte) {
+	m.StatusCode = resp.StatusCode
+	m.RawBody = body
+	m.RateLimit = parseRateLimit(resp)
+}
+
+// User is the user data returned when a user is created
+type User struct {
+	ID int64 `json:"id" xml:"id"`
 }
 
 // AuthyUser is for use when creating users with Authy API
 // the new user endpoitn expects at lease the cellphone and country code params
+// It can be stored as json, e.g. to queue users to be created later
 type AuthyUser struct {
-	Email           string `url:"user[email],omitempty"`
-	Cellphone       string `url:"user[cellphone]"`
-	CountryCode     string `url:"user[country_code]"`
-	SendInstallLink bool   `url:"send_install_link_via_sms,omitempty"`
+	Email           string `url:"user[email],omitempty" json:"email,omitempty"` // optional, authy uses it for account recovery
+	Cellphone       string `url:"user[cellphone]" json:"cellphone"`
+	CountryCode     string `url:"user[country_code]" json:"country_code"`
+	SendInstallLink bool   `url:"send_install_link_via_sms,omitempty" json:"send_install_link_via_sms,omitempty"`
+
+	// IdempotencyKey is sent as the Idempotency-Key header so a proxy in
+	// front of authy can dedupe retried creates. Authy itself ignores it
+	IdempotencyKey string `url:"-" json:"idempotency_key,omitempty"`
 }
 
 // CreateUser creates a user - must provide cellphone number
 // and country code for request to be processed
 func (c *Client) CreateUser(au AuthyUser) (int64, error) {
+	return c.CreateUserWithContext(context.Background(), au)
+}
+
+// CreateUserWithContext is like CreateUser but uses the provided context
+func (c *Client) CreateUserWithContext(ctx context.Context, au AuthyUser) (int64, error) {
+	msg, err := c.CreateUserDetailedWithContext(ctx, au)
+	if err != nil {
+		return 0, err
+	}
+	return msg.User.ID, nil
+}
+
+// CreateUserDetailed is like CreateUser but returns the full response, which
+// includes whether the install link SMS was sent when SendInstallLink is set
+func (c *Client) CreateUserDetailed(au AuthyUser) (*ResponseMessage, error) {
+	return c.CreateUserDetailedWithContext(context.Background(), au)
+}
+
+// CreateUserDetailedWithContext is like CreateUserDetailed but uses the
+// provided context
+func (c *Client) CreateUserDetailedWithContext(ctx context.Context, au AuthyUser) (*ResponseMessage, error) {
+	if err := c.normalizeUser(&au); err != nil {
+		return nil, err
+	}
+
+	// the cache is keyed by phone number so can't be shared between apps
+	cache := c.createdUsers
+	if _, ok := ctx.Value(apiKeyKey{}).(string); ok {
+		cache = nil
+	}
+
+	if cache != nil {
+		if id, ok := cache.get(au); ok {
+			return &ResponseMessage{User: User{ID: id}, Success: true}, nil
+		}
+	}
+
+	msg, err := c.createUser(ctx, au)
+	if err != nil {
+		return nil, err
+	}
+
+	if cache != nil {
+		cache.put(au, msg.User.ID)
+	}
+	return msg, nil
+}
+
+// normalizeUser fills in the client's default country code, checks au has
+// the details authy requires to create a user and trims the whitespace
+// often left around emails by form input
+func (c *Client) normalizeUser(au *AuthyUser) error {
+	if au.CountryCode == "" {
+		au.CountryCode = c.defaultCountryCode
+	}
 	if au.Cellphone == "" || au.CountryCode == "" {
-		return 0, fmt.Errorf("AUTHY: insufficient data provided to create user")
+		return fmt.Errorf("AUTHY: insufficient data provided to create user")
+	}
+	if err := validatePhone(au.CountryCode, au.Cellphone); err != nil {
+		return err
+	}
+
+	au.Email = strings.TrimSpace(au.Email)
+	if au.Email != "" && !strings.Contains(au.Email, "@") {
+		return fmt.Errorf("AUTHY: invalid email %q", au.Email)
+	}
+	return nil
+}
+
+// createUser posts the user to the new user endpoint, sending the user's
+// IdempotencyKey as a header if one is set
+func (c *Client) createUser(ctx context.Context, au AuthyUser) (*ResponseMessage, error) {
+	req, err := c.NewRequestWithContext(ctx, "POST", "users/new", au)
+	if err != nil {
+		return nil, err
+	}
+	if au.IdempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", au.IdempotencyKey)
 	}
 
 	resource := new(ResponseMessage)
-	err := c.Post("users/new", au, resource)
+	err = c.do(req, resource)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	if !resource.Success {
-		return 0, fmt.Errorf("AUTHY: create not successful %v", resource.Message)
+		return nil, resource.apiError()
 	}
 
-	return resource.User.ID, nil
+	return resource, nil
+}
+
+// ResendInstallLink sends the Authy app install link by SMS to an existing
+// user. Authy has no standalone endpoint for this, so the user is registered
+// again with the same details, which returns the existing user and sends the
+// link. The cellphone and country code must match the user's
+func (c *Client) ResendInstallLink(au AuthyUser) (*ResponseMessage, error) {
+	return c.ResendInstallLinkWithContext(context.Background(), au)
 }
 
-// RemoveUser removes a user from Authy API
+// ResendInstallLinkWithContext is like ResendInstallLink but uses the
+// provided context
+func (c *Client) ResendInstallLinkWithContext(ctx context.Context, au AuthyUser) (*ResponseMessage, error) {
+	if err := c.normalizeUser(&au); err != nil {
+		return nil, err
+	}
+
+	// skip the created users cache so the link is always sent
+	au.SendInstallLink = true
+	return c.createUser(ctx, au)
+}
+
+// RemoveUser removes a user from Authy API. This is how users are deleted,
+// authy has no DELETE endpoint and removes users POSTed to the remove
+// endpoint.
+//
+// Authy has no endpoint to rotate or regenerate a user's secret. The seed
+// belongs to the user's Authy app account rather than to the app, so the
+// nearest an app can get is removing the user, which stops their tokens
+// being accepted, and creating them again
 func (c *Client) RemoveUser(authyUserID int64) error {
+	return c.RemoveUserWithContext(context.Background(), authyUserID)
+}
+
+// RemoveUserWithContext is like RemoveUser but uses the provided context
+func (c *Client) RemoveUserWithContext(ctx context.Context, authyUserID int64) error {
 	path := fmt.Sprintf("users/%d/remove", authyUserID)
 	resource := new(ResponseMessage)
-	err := c.Post(path, nil, resource)
+	// a non-2xx response is returned as an *APIError by PostWithContext
+	err := c.PostWithContext(ctx, path, nil, resource)
 	if err != nil {
 		return err
 	}
 
 	if !resource.Success {
-		return fmt.Errorf("%v", resource.Message)
+		return resource.apiError()
 	}
 
 	return nil
 }
 
+// removePollInterval is how often RemoveUserAndWait checks the user's status
+var removePollInterval = time.Second
+
+// RemoveUserAndWait removes a user then polls their status until authy
+// reports the user isn't found. An error is returned if the user still
+// exists when ctx is done, or straight away if a status request is rejected,
+// e.g. because the API key is invalid
+func (c *Client) RemoveUserAndWait(ctx context.Context, authyUserID int64) error {
+	if err := c.RemoveUserWithContext(ctx, authyUserID); err != nil {
+		return err
+	}
+
+	err := poll(ctx, every(removePollInterval), func() (bool, error) {
+		// registered only means the user has the app, so it says nothing
+		// about whether the user still exists
+		_, err := c.UserStatusWithContext(ctx, authyUserID)
+		var apiErr *APIError
+		switch {
+		case err == nil:
+			return false, nil
+		case errors.Is(err, ErrUserNotFound):
+			return true, nil
+		case errors.Is(err, ErrNetwork),
+			errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500):
+			// the status endpoint may fail briefly while the removal
+			// propagates
+			return false, nil
+		}
+		return false, err
+	})
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("AUTHY: user %d still exists after removal: %w", authyUserID, err)
+	}
+	return err
+}
+
+// registrationQR is the body posted to the user secret endpoint
+type registrationQR struct {
+	Label  string `url:"label,omitempty"`
+	QRSize int    `url:"qr_size,omitempty"`
+}
+
+// GetRegistrationQR generates a QR code the user can scan with an
+// authenticator app to enroll, and returns the url of the image. The label
+// and size in pixels of the QR code are optional
+func (c *Client) GetRegistrationQR(authyUserID int64, label string, size int) (string, error) {
+	return c.GetRegistrationQRWithContext(context.Background(), authyUserID, label, size)
+}
+
+// GetRegistrationQRWithContext is like GetRegistrationQR but uses the
+// provided context
+func (c *Client) GetRegistrationQRWithContext(ctx context.Context, authyUserID int64, label string, size int) (string, error) {
+	if authyUserID == 0 {
+		return "", fmt.Errorf("AUTHY: authyUserID not provided")
+	}
+
+	path := fmt.Sprintf("users/%d/secret", authyUserID)
+	resource := struct {
+		QRCode  string `json:"qr_code" xml:"qr_code"`
+		Message string `json:"message" xml:"message"`
+		Success bool   `json:"success" xml:"success"`
+	}{}
+	err := c.PostWithContext(ctx, path, registrationQR{Label: label, QRSize: size}, &resource)
+	if err != nil {
+		return "", err
+	}
+
+	if !resource.Success {
+		return "", fmt.Errorf("AUTHY: registration QR code not successful %v", resource.Message)
+	}
+
+	return resource.QRCode, nil
+}
+
+// ActivityType is a kind of user activity authy's risk engine understands
+type ActivityType string
+
+// Activities that can be registered for a user
+const (
+	ActivityPasswordReset ActivityType = "password_reset"
+	ActivityBanned        ActivityType = "banned"
+	ActivityUnbanned      ActivityType = "unbanned"
+	ActivityCookieLogin   ActivityType = "cookie_login"
+)
+
+// registerActivity is the body posted to the register activity endpoint
+type registerActivity struct {
+	Type ActivityType  `url:"type"`
+	Data bracketParams `url:"data,omitempty"`
+}
+
+// RegisterActivity records an activity for the user with authy for fraud
+// analysis, along with any data describing it
+func (c *Client) RegisterActivity(authyUserID int64, activityType ActivityType, data map[string]string) error {
+	return c.RegisterActivityWithContext(context.Background(), authyUserID, activityType, data)
+}
+
+// RegisterActivityWithContext is like RegisterActivity but uses the provided
+// context
+func (c *Client) RegisterActivityWithContext(ctx context.Context, authyUserID int64, activityType ActivityType, data map[string]string) error {
+	switch activityType {
+	case ActivityPasswordReset, ActivityBanned, ActivityUnbanned, ActivityCookieLogin:
+	default:
+		return fmt.Errorf("AUTHY: unknown activity type %q", activityType)
+	}
+
+	path := fmt.Sprintf("users/%d/register_activity", authyUserID)
+	resource := new(ResponseMessage)
+	err := c.PostWithContext(ctx, path, registerActivity{Type: activityType, Data: data}, resource)
+	if err != nil {
+		return err
+	}
+
+	if !resource.Success {
+		return resource.apiError()
+	}
+
+	return nil
+}
+
+// UserDetails returns the current status of the provided user ID, with the
+// user's details and devices
+func (c *Client) UserDetails(authyUserID int64) (*Status, error) {
+	return c.UserDetailsWithContext(context.Background(), authyUserID)
+}
+
+// UserDetailsWithContext is like UserDetails but uses the provided context
+func (c *Client) UserDetailsWithContext(ctx context.Context, authyUserID int64) (*Status, error) {
+	msg, err := c.UserStatusWithContext(ctx, authyUserID)
+	if err != nil {
+		return nil, err
+	}
+	return &msg.Status, nil
+}
+
 // UserStatus requests the current status of the provided user ID
 // in the authy API
 func (c *Client) UserStatus(authyUserID int64) (*ResponseMessage, error) {
+	return c.UserStatusWithContext(context.Background(), authyUserID)
+}
+
+// UserStatusWithContext is like UserStatus but uses the provided context
+func (c *Client) UserStatusWithContext(ctx context.Context, authyUserID int64) (*ResponseMessage, error) {
 	path := fmt.Sprintf("users/%d/status", authyUserID)
 	msg := new(ResponseMessage)
-	err := c.Get(path, msg)
+	err := c.GetWithContext(ctx, path, msg)
 	if err != nil {
 		return nil, err
 	}
 	return msg, nil
 }
 
-type status struct {
-	AuthyID     int64  `json:"authy_id"`
-	Confirmed   bool   `json:"confirmed"`
-	Registered  bool   `json:"registered"`
-	CountryCode int    `json:"country_code"`
-	PhoneNumber string `json:"phone_number"`
-	Email       string `json:"email"`
+// Status is a user's details and registered devices as returned by
+// UserStatus
+type Status struct {
+	AuthyID     int64 `json:"authy_id" xml:"authy_id"`
+	Confirmed   bool  `json:"confirmed" xml:"confirmed"`
+	Registered  bool  `json:"registered" xml:"registered"`
+	CountryCode int   `json:"country_code" xml:"country_code"`
+	// PhoneNumber is usually masked by authy, e.g. "XXX-XXX-1234", check
+	// PhoneMasked before treating it as a full number
+	PhoneNumber string   `json:"phone_number" xml:"phone_number"`
+	Email       string   `json:"email" xml:"email"`
+	Devices     []Device `json:"devices" xml:"devices>device"`
+}
+
+// RegistrationComplete reports whether the user has both registered the
+// Authy app and confirmed their phone. It is what IsRegistered, Enroll and
+// the Confirmed RegistrationState mean by a user having registered
+func (s Status) RegistrationComplete() bool {
+	return s.Registered && s.Confirmed
+}
+
+// PhoneMasked reports whether authy hid some of the digits of PhoneNumber
+func (s Status) PhoneMasked() bool {
+	return strings.ContainsAny(s.PhoneNumber, "Xx*")
+}
+
+// PhoneLastDigits returns the digits at the end of PhoneNumber that authy
+// doesn't mask, which are safe to show the user
+func (s Status) PhoneLastDigits() string {
+	end := len(s.PhoneNumber)
+	start := end
+	for start > 0 && s.PhoneNumber[start-1] >= '0' && s.PhoneNumber[start-1] <= '9' {
+		start--
+	}
+	return s.PhoneNumber[start:end]
+}
+
+// CountryCallingCode returns CountryCode in the +61 form, or "" if authy
+// didn't return one
+func (s Status) CountryCallingCode() string {
+	if s.CountryCode == 0 {
+		return ""
+	}
+	return "+" + strconv.Itoa(s.CountryCode)
+}
+
+// RegistrationState is how far a user has got through registering with authy
+type RegistrationState int
+
+// States a user's registration can be in
+const (
+	Unregistered RegistrationState = iota
+	Pending                        // part way, the app is registered or the phone confirmed but not both
+	Confirmed                      // registration is complete, see Status.RegistrationComplete
+)
+
+func (s RegistrationState) String() string {
+	switch s {
+	case Pending:
+		return "pending"
+	case Confirmed:
+		return "confirmed"
+	}
+	return "unregistered"
+}
+
+// UserRegistration summarises a user's status for business logic
+type UserRegistration struct {
+	State RegistrationState
+	// DeviceCount is the number of devices the user can receive tokens on,
+	// including the sms and voice fallbacks, with none a OTP can't fall back
+	// to another device. Client.AppDeviceCount counts only those with the app
+	DeviceCount int
+}
+
+// UserRegistrationStatus interprets the status of the provided user ID into
+// a RegistrationState, use UserStatus for the raw response
+func (c *Client) UserRegistrationStatus(authyUserID int64) (*UserRegistration, error) {
+	return c.UserRegistrationStatusWithContext(context.Background(), authyUserID)
+}
+
+// UserRegistrationStatusWithContext is like UserRegistrationStatus but uses
+// the provided context
+func (c *Client) UserRegistrationStatusWithContext(ctx context.Context, authyUserID int64) (*UserRegistration, error) {
+	msg, err := c.UserStatusWithContext(ctx, authyUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	reg := &UserRegistration{DeviceCount: len(msg.Status.Devices)}
+	switch {
+	case msg.Status.RegistrationComplete():
+		reg.State = Confirmed
+	case msg.Status.Registered || msg.Status.Confirmed:
+		reg.State = Pending
+	default:
+		reg.State = Unregistered
+	}
+	return reg, nil
+}
+
+// CanReceiveSMS reports whether a SMS will be delivered to the user without
+// forcing it. Authy doesn't send SMS to users with the app registered on a
+// device, as they can generate tokens themselves
+func (c *Client) CanReceiveSMS(authyUserID int64) (bool, error) {
+	return c.CanReceiveSMSWithContext(context.Background(), authyUserID)
+}
+
+// CanReceiveSMSWithContext is like CanReceiveSMS but uses the provided context
+func (c *Client) CanReceiveSMSWithContext(ctx context.Context, authyUserID int64) (bool, error) {
+	msg, err := c.UserStatusWithContext(ctx, authyUserID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, d := range msg.Status.Devices {
+		// sms and voice are listed as devices but don't block SMS
+		if d.HasApp() {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Delivery is the channel a OTP is delivered to the user through
+type Delivery string
+
+// Channels supported for OTP delivery
+const (
+	DeliverySMS  Delivery = "sms"
+	DeliveryCall Delivery = "call"
+
+	// DeliveryWhatsApp is only supported by StartPhoneVerification, and
+	// only for accounts Twilio has enabled it for
+	DeliveryWhatsApp Delivery = "whatsapp"
+)
+
+// OTPOptions configures how a OTP is sent to a user
+type OTPOptions struct {
+	Via           Delivery `url:"-"`               // defaults to DeliverySMS if not provided
+	Force         bool     `url:"force,omitempty"` // send even if the user has the Authy app installed
+	Action        string   `url:"action,omitempty"`
+	ActionMessage string   `url:"action_message,omitempty"` // only sent with an Action
+	Locale        string   `url:"locale,omitempty"`         // e.g. "es", authy picks one from the user's country if not provided
+
+	// TokenLength can't be set per request, authy tokens are 6 to 8 digits
+	// as configured for the app in the Twilio console. Any non-zero value
+	// is rejected so it isn't silently ignored
+	TokenLength int `url:"-"`
 }
 
 // SendOTP triggers a OTP to be sent to the user based on their authy ID
 // requires a user to be already added to authy
 func (c *Client) SendOTP(authyUserID int64) (*ResponseMessage, error) {
-	return c.SendOTPWithAction(authyUserID, "", "")
+	return c.SendOTPWithContext(context.Background(), authyUserID)
+}
+
+// SendOTPWithContext is like SendOTP but uses the provided context
+func (c *Client) SendOTPWithContext(ctx context.Context, authyUserID int64) (*ResponseMessage, error) {
+	return c.SendOTPWithOptionsWithContext(ctx, authyUserID, OTPOptions{})
+}
+
+// SendOTPViaCall triggers a OTP to be read out to the user over a phone call
+// requires a user to be already added to authy
+func (c *Client) SendOTPViaCall(authyUserID int64) (*ResponseMessage, error) {
+	return c.SendOTPViaCallWithContext(context.Background(), authyUserID)
+}
+
+// SendOTPViaCallWithContext is like SendOTPViaCall but uses the provided context
+func (c *Client) SendOTPViaCallWithContext(ctx context.Context, authyUserID int64) (*ResponseMessage, error) {
+	return c.SendOTPWithOptionsWithContext(ctx, authyUserID, OTPOptions{Via: DeliveryCall})
+}
+
+// SendOTPForced triggers a OTP to be sent by SMS even if the user has the
+// Authy app installed and could generate the token themselves
+func (c *Client) SendOTPForced(authyUserID int64) (*ResponseMessage, error) {
+	return c.SendOTPForcedWithContext(context.Background(), authyUserID)
+}
+
+// SendOTPForcedWithContext is like SendOTPForced but uses the provided context
+func (c *Client) SendOTPForcedWithContext(ctx context.Context, authyUserID int64) (*ResponseMessage, error) {
+	return c.SendOTPWithOptionsWithContext(ctx, authyUserID, OTPOptions{Force: true})
 }
 
 // SendOTPWithAction triggers a OTP to be sent to the user based with a
 // custom message on their authy ID requires a user to be already added to authy
 // https://www.twilio.com/docs/authy/api/one-time-passwords
 func (c *Client) SendOTPWithAction(authyUserID int64, action, actionMessage string) (*ResponseMessage, error) {
-	path := fmt.Sprintf("sms/%d", authyUserID)
-	if action != "" {
-		//doesn't work?
-		path = fmt.Sprintf("%s?action=%s", path, action)
-		//doesn't work
-		if actionMessage != "" {
-			path = fmt.Sprintf("%s&action_message=%s", path, actionMessage)
+	return c.SendOTPWithActionWithContext(context.Background(), authyUserID, action, actionMessage)
+}
+
+// SendOTPWithActionWithContext is like SendOTPWithAction but uses the
+// provided context
+func (c *Client) SendOTPWithActionWithContext(ctx context.Context, authyUserID int64, action, actionMessage string) (*ResponseMessage, error) {
+	return c.SendOTPWithOptionsWithContext(ctx, authyUserID, OTPOptions{Action: action, ActionMessage: actionMessage})
+}
+
+// SentOTP is the result of sending a OTP to a user
+type SentOTP struct {
+	Message string
+
+	// Cellphone is the masked number a SMS was sent to
+	Cellphone string
+
+	// Ignored is set when authy didn't send the SMS, e.g. because the user
+	// has the app installed and can generate the token themselves
+	Ignored bool
+}
+
+// SendOTPWithResult is like SendOTPWithOptions but returns what authy did
+// with the OTP rather than the raw response
+func (c *Client) SendOTPWithResult(authyUserID int64, opts OTPOptions) (*SentOTP, error) {
+	return c.SendOTPWithResultWithContext(context.Background(), authyUserID, opts)
+}
+
+// SendOTPWithResultWithContext is like SendOTPWithResult but uses the
+// provided context
+func (c *Client) SendOTPWithResultWithContext(ctx context.Context, authyUserID int64, opts OTPOptions) (*SentOTP, error) {
+	msg, err := c.SendOTPWithOptionsWithContext(ctx, authyUserID, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &SentOTP{Message: msg.Message, Cellphone: msg.Cellphone, Ignored: msg.Ignored}, nil
+}
+
+// SendOTPWithOptions triggers a OTP to be sent to the user through the
+// channel and with the parameters given in opts
+func (c *Client) SendOTPWithOptions(authyUserID int64, opts OTPOptions) (*ResponseMessage, error) {
+	return c.SendOTPWithOptionsWithContext(context.Background(), authyUserID, opts)
+}
+
+// SendOTPWithOptionsWithContext is like SendOTPWithOptions but uses the
+// provided context
+func (c *Client) SendOTPWithOptionsWithContext(ctx context.Context, authyUserID int64, opts OTPOptions) (*ResponseMessage, error) {
+	via := opts.Via
+	if via == "" {
+		via = DeliverySMS
+	}
+	if via != DeliverySMS && via != DeliveryCall {
+		return nil, fmt.Errorf("AUTHY: unsupported OTP delivery %q", via)
+	}
+	if opts.TokenLength != 0 {
+		return nil, fmt.Errorf("AUTHY: token length is set for the app in the Twilio console and can't be set per request")
+	}
+
+	// the action message is only meaningful alongside an action
+	if opts.Action == "" {
+		opts.ActionMessage = ""
+	}
+
+	if c.autoForceSMS && via == DeliverySMS && !opts.Force {
+		force, err := c.userHasApp(ctx, authyUserID)
+		if err != nil {
+			return nil, err
 		}
+		opts.Force = force
+	}
+
+	path, err := addOptions(fmt.Sprintf("%s/%d", via, authyUserID), opts)
+	if err != nil {
+		return nil, err
 	}
+
 	msg := new(ResponseMessage)
-	err := c.Get(path, msg)
+	err = c.GetWithContext(ctx, path, msg)
 	if err != nil {
 		return msg, err
 	}
@@ -276,66 +1300,371 @@ func (c *Client) SendOTPWithAction(authyUserID int64, action, actionMessage stri
 }
 
 // CheckOTPToken checks with authy API whether the provided token is
-// valid in order to grant access - response
-// can't use standard response message with this endpoint because it returns "true" rather than true
-// for json values - could write a customer UnmarshalJSON for the struct to clean it up
-// this method is really ugly because the authy API sends back different types for true (string) and false (bool)
-// it currently throws an error on unmarshal instead of denying based on the reading of the response
+// valid in order to grant access. An invalid token is reported as false
+// with a nil error, errors are only returned when the check couldn't be made.
+//
+// Authy only accepts the token as part of the path of a GET request, there is
+// no POST or query parameter form. The path is encrypted by TLS in transit,
+// but a proxy that terminates TLS may log it. The client redacts the token
+// from its own logs, errors and WithDebug output
 func (c *Client) CheckOTPToken(authyUserID int64, token string) (bool, error) {
+	return c.CheckOTPTokenWithContext(context.Background(), authyUserID, token)
+}
+
+// CheckOTPTokenWithContext is like CheckOTPToken but uses the provided context
+func (c *Client) CheckOTPTokenWithContext(ctx context.Context, authyUserID int64, token string) (bool, error) {
+	return c.CheckOTPTokenWithOptionsWithContext(ctx, authyUserID, token, VerifyOptions{})
+}
+
+// VerifyOptions configures how a token is verified
+type VerifyOptions struct {
+	// Force verification even if the user isn't registered, which some
+	// soft tokens need to validate reliably
+	Force bool `url:"force,omitempty"`
+}
+
+// CheckOTPTokenWithOptions is like CheckOTPToken but passes the options
+// provided to the verify endpoint
+func (c *Client) CheckOTPTokenWithOptions(authyUserID int64, token string, opts VerifyOptions) (bool, error) {
+	return c.CheckOTPTokenWithOptionsWithContext(context.Background(), authyUserID, token, opts)
+}
+
+// CheckOTPTokenWithOptionsWithContext is like CheckOTPTokenWithOptions but
+// uses the provided context
+func (c *Client) CheckOTPTokenWithOptionsWithContext(ctx context.Context, authyUserID int64, token string, opts VerifyOptions) (bool, error) {
+	result, err := c.VerifyOTPTokenWithOptionsWithContext(ctx, authyUserID, token, opts)
+	if err != nil {
+		return false, err
+	}
+	return result.Valid, nil
+}
+
+// VerificationReason explains why a token failed verification
+type VerificationReason string
+
+// Reasons a token can fail verification
+const (
+	ReasonNone            VerificationReason = ""
+	ReasonInvalid         VerificationReason = "invalid"
+	ReasonUsedRecently    VerificationReason = "used_recently"
+	ReasonExpired         VerificationReason = "expired"
+	ReasonTooManyAttempts VerificationReason = "too_many_attempts"
+)
+
+// VerificationResult is the outcome of verifying a token, including the
+// message and reason authy gave when the token was rejected
+type VerificationResult struct {
+	Valid     bool
+	Reason    VerificationReason
+	Message   string
+	ErrorCode string
+
+	// Resent is set by VerifyOTPTokenOrResend when a fresh token was sent
+	// to the user because this one was expired or used recently
+	Resent bool
+}
+
+// VerifyOTPToken is like CheckOTPToken but returns the details of why a
+// token was rejected so callers can tell an expired token from a lockout
+func (c *Client) VerifyOTPToken(authyUserID int64, token string) (*VerificationResult, error) {
+	return c.VerifyOTPTokenWithOptionsWithContext(context.Background(), authyUserID, token, VerifyOptions{})
+}
+
+// VerifyOTPTokenWithContext is like VerifyOTPToken but uses the provided context
+func (c *Client) VerifyOTPTokenWithContext(ctx context.Context, authyUserID int64, token string) (*VerificationResult, error) {
+	return c.VerifyOTPTokenWithOptionsWithContext(ctx, authyUserID, token, VerifyOptions{})
+}
+
+// VerifyOTPTokenWithOptions is like VerifyOTPToken but passes the options
+// provided to the verify endpoint
+func (c *Client) VerifyOTPTokenWithOptions(authyUserID int64, token string, opts VerifyOptions) (*VerificationResult, error) {
+	return c.VerifyOTPTokenWithOptionsWithContext(context.Background(), authyUserID, token, opts)
+}
+
+// VerifyOTPTokenWithOptionsWithContext is like VerifyOTPTokenWithOptions but
+// uses the provided context
+func (c *Client) VerifyOTPTokenWithOptionsWithContext(ctx context.Context, authyUserID int64, token string, opts VerifyOptions) (*VerificationResult, error) {
 	if authyUserID == 0 || token == "" {
-		return false, fmt.Errorf("authyUserID or token not provided")
+		return nil, fmt.Errorf("authyUserID or token not provided")
+	}
+	if !validToken(token) {
+		return nil, fmt.Errorf("AUTHY: token must be 6 to 8 digits")
 	}
 
-	path := fmt.Sprintf("verify/%s/%d", token, authyUserID)
-	req, err := c.NewRequest("GET", path, nil)
+	path, err := addOptions(fmt.Sprintf("verify/%s/%d", url.PathEscape(token), authyUserID), opts)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	resp, err := c.Client.Do(req)
+	msg := new(verifyResponse)
+	err = c.GetWithContext(ctx, path, msg)
 	if err != nil {
-		return false, err
+		// authy responds to a rejected token with a 401, but also to a
+		// rejected api key, which must not look like a wrong token
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || !tokenRejected(msg) {
+			return nil, err
+		}
 	}
 
-	if resp.StatusCode != 200 {
-		return false, fmt.Errorf("invalid token")
+	result := &VerificationResult{
+		Valid:     msg.Success && msg.Token == "is valid",
+		Message:   msg.Message,
+		ErrorCode: msg.ErrorCode,
+	}
+	if !result.Valid {
+		result.Reason = verificationReason(msg)
 	}
+	return result, nil
+}
+
+// VerifyOTPTokenOrResend is like VerifyOTPToken but when resend is true and
+// the token was expired or used recently, a fresh token is sent to the user
+// with SendOTP and the result's Resent is set. If sending fails the result is
+// returned along with the error
+func (c *Client) VerifyOTPTokenOrResend(authyUserID int64, token string, resend bool) (*VerificationResult, error) {
+	return c.VerifyOTPTokenOrResendWithContext(context.Background(), authyUserID, token, resend)
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
+// VerifyOTPTokenOrResendWithContext is like VerifyOTPTokenOrResend but uses
+// the provided context
+func (c *Client) VerifyOTPTokenOrResendWithContext(ctx context.Context, authyUserID int64, token string, resend bool) (*VerificationResult, error) {
+	result, err := c.VerifyOTPTokenWithContext(ctx, authyUserID, token)
 	if err != nil {
-		log.Println("authy-go CheckOTPToken: malformed response")
-		return false, err
+		return nil, err
+	}
+	if !resend || (result.Reason != ReasonExpired && result.Reason != ReasonUsedRecently) {
+		return result, nil
 	}
 
-	msg := struct {
-		Success string `json:"success"`
-		Token   string `json:"token"`
+	if _, err := c.SendOTPWithContext(ctx, authyUserID); err != nil {
+		return result, fmt.Errorf("AUTHY: token was %s but a new one couldn't be sent: %w", result.Reason, err)
+	}
+	result.Resent = true
+	return result, nil
+}
+
+// VerifyAppToken verifies a TOTP token generated by the Authy app. Unlike
+// SMS tokens, app tokens are checked with force set, so they validate for
+// users who installed the app but never confirmed their phone. The result's
+// Reason tells an invalid token from one that was used recently
+func (c *Client) VerifyAppToken(authyUserID int64, token string) (*VerificationResult, error) {
+	return c.VerifyAppTokenWithContext(context.Background(), authyUserID, token)
+}
+
+// VerifyAppTokenWithContext is like VerifyAppToken but uses the provided context
+func (c *Client) VerifyAppTokenWithContext(ctx context.Context, authyUserID int64, token string) (*VerificationResult, error) {
+	return c.VerifyOTPTokenWithOptionsWithContext(ctx, authyUserID, token, VerifyOptions{Force: true})
+}
+
+// validToken reports whether the token has the 6 to 8 digit format authy
+// tokens are issued in
+func validToken(token string) bool {
+	if len(token) < 6 || len(token) > 8 {
+		return false
+	}
+	for _, r := range token {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenRejected reports whether a 401 from the verify endpoint was about the
+// token, rather than e.g. error code 60001 for an invalid api key
+func tokenRejected(msg *verifyResponse) bool {
+	switch msg.ErrorCode {
+	case "60003", "60020": // max attempts reached, token is invalid
+		return true
+	case "":
+		m := strings.ToLower(msg.Message)
+		return msg.Token != "" || strings.Contains(m, "token") || strings.Contains(m, "attempts")
+	}
+	return false
+}
+
+// verificationReason works out why a token was rejected from the error code
+// and message authy responded with
+func verificationReason(msg *verifyResponse) VerificationReason {
+	// 60003 is returned once the maximum number of attempts is reached
+	if msg.ErrorCode == "60003" {
+		return ReasonTooManyAttempts
+	}
+
+	m := strings.ToLower(msg.Message)
+	switch {
+	case strings.Contains(m, "too many") || strings.Contains(m, "max attempts"):
+		return ReasonTooManyAttempts
+	case strings.Contains(m, "used recently"):
+		return ReasonUsedRecently
+	case strings.Contains(m, "expired"):
+		return ReasonExpired
+	}
+	return ReasonInvalid
+}
+
+// verifyResponse is the data returned by the verify endpoint
+type verifyResponse struct {
+	Success   bool   `json:"success" xml:"success"`
+	Token     string `json:"token" xml:"token"`
+	Message   string `json:"message" xml:"message"`
+	ErrorCode string `json:"error_code" xml:"error_code"`
+}
+
+// UnmarshalJSON normalizes success, which authy sends as the string "true"
+// for valid tokens and the boolean false for invalid ones
+func (v *verifyResponse) UnmarshalJSON(data []byte) error {
+	raw := struct {
+		Success   flexBool `json:"success"`
+		Token     string   `json:"token"`
+		Message   string   `json:"message"`
+		ErrorCode string   `json:"error_code"`
 	}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
 
-	err = json.Unmarshal(body, &msg)
+	v.Success = bool(raw.Success)
+	v.Token = raw.Token
+	v.Message = raw.Message
+	v.ErrorCode = raw.ErrorCode
+	return nil
+}
+
+// Device is an authenticator the user has registered with authy
+type Device struct {
+	ID                    int64   `json:"id" xml:"id"`
+	OSType                *string `json:"os_type" xml:"os_type"`
+	RegistrationDate      *string `json:"registration_date" xml:"registration_date"`
+	RegistrationMethod    *string `json:"registration_method" xml:"registration_method"`
+	RegistrationRegion    *string `json:"registration_region" xml:"registration_region"`
+	RegistrationCity      *string `json:"registration_city" xml:"registration_city"`
+	Country               *string `json:"country" xml:"country"`
+	Region                *string `json:"region" xml:"region"`
+	City                  *string `json:"city" xml:"city"`
+	IP                    *string `json:"ip" xml:"ip"`
+	LastAccountRecoveryAt *string `json:"last_account_recovery_at" xml:"last_account_recovery_at"`
+	LastSyncDate          *string `json:"last_sync_date" xml:"last_sync_date"`
+}
+
+// UnmarshalJSON accepts either a device object or the bare device type
+// string, e.g. "android", that the status endpoint lists devices as
+func (d *Device) UnmarshalJSON(data []byte) error {
+	var osType string
+	if err := json.Unmarshal(data, &osType); err == nil {
+		*d = Device{OSType: &osType}
+		return nil
+	}
+
+	// dates are sent as unix timestamps or strings depending on the device
+	type plain Device
+	aux := struct {
+		*plain
+		RegistrationDate      json.RawMessage `json:"registration_date"`
+		LastAccountRecoveryAt json.RawMessage `json:"last_account_recovery_at"`
+		LastSyncDate          json.RawMessage `json:"last_sync_date"`
+	}{plain: (*plain)(d)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	var err error
+	if d.RegistrationDate, err = rawString(aux.RegistrationDate); err != nil {
+		return err
+	}
+	if d.LastAccountRecoveryAt, err = rawString(aux.LastAccountRecoveryAt); err != nil {
+		return err
+	}
+	d.LastSyncDate, err = rawString(aux.LastSyncDate)
+	return err
+}
+
+// rawString returns a json string or number as a string, or nil if it's
+// missing or null
+func rawString(raw json.RawMessage) (*string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return &s, nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return nil, err
+	}
+	s = n.String()
+	return &s, nil
+}
+
+// RegisteredAt parses RegistrationDate, which authy sends as either a unix
+// timestamp or an RFC 3339 date. ok is false if it's missing or unrecognized
+func (d Device) RegisteredAt() (t time.Time, ok bool) {
+	if d.RegistrationDate == nil {
+		return time.Time{}, false
+	}
+	if secs, err := strconv.ParseInt(*d.RegistrationDate, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), true
+	}
+	t, err := time.Parse(time.RFC3339, *d.RegistrationDate)
+	return t, err == nil
+}
+
+// HasApp reports whether the device is running the Authy app, rather than
+// being the sms or voice fallback authy lists alongside devices
+func (d Device) HasApp() bool {
+	return d.OSType != nil && *d.OSType != "sms" && *d.OSType != "voice"
+}
+
+// userHasApp reports whether any of the user's devices run the Authy app
+func (c *Client) userHasApp(ctx context.Context, authyUserID int64) (bool, error) {
+	devices, err := c.ListDevicesWithContext(ctx, authyUserID)
 	if err != nil {
-		log.Println("authy-go CheckOTPToken: error unmarshaling authy API response")
-		//log.Error().Err(err).Msg("error unmarshaling authy API response")
 		return false, err
 	}
-
-	if msg.Success == "true" && msg.Token == "is valid" {
-		return true, nil
+	for _, d := range devices {
+		if d.HasApp() {
+			return true, nil
+		}
 	}
 	return false, nil
 }
 
-type device struct {
-	ID     int64   `json:"id"`
-	OSType *string `json:"os_type"`
-	/*	RegistrationDate      *string `json:"registration_date"`
-		RegistrationMethod    *string `json:"registration_method"`
-		RegistrationRegion    *string `json:"registration_region"`
-		RegistrationCity      *string `json:"registration_city"`
-		Country               *string `json:"country"`
-		Region                *string `json:"region"`
-		City                  *string `json:"city"`
-		IP                    *string `json:"ip"`
-		LastAccountRecoveryAt *string `json:"last_account_recovery_at"`
-		LastSyncDate          *string `json:"last_sync_date"`*/
+// ListDevices returns the devices the user has registered, including the
+// sms and voice fallbacks
+func (c *Client) ListDevices(authyUserID int64) ([]Device, error) {
+	return c.ListDevicesWithContext(context.Background(), authyUserID)
+}
+
+// ListDevicesWithContext is like ListDevices but uses the provided context
+func (c *Client) ListDevicesWithContext(ctx context.Context, authyUserID int64) ([]Device, error) {
+	msg, err := c.UserStatusWithContext(ctx, authyUserID)
+	if err != nil {
+		return nil, err
+	}
+	return msg.Status.Devices, nil
+}
+
+// AppDeviceCount returns the number of devices the user has the Authy app on.
+// Unlike UserRegistration.DeviceCount the sms and voice fallbacks aren't
+// counted
+func (c *Client) AppDeviceCount(authyUserID int64) (int, error) {
+	return c.AppDeviceCountWithContext(context.Background(), authyUserID)
+}
+
+// AppDeviceCountWithContext is like AppDeviceCount but uses the provided context
+func (c *Client) AppDeviceCountWithContext(ctx context.Context, authyUserID int64) (int, error) {
+	devices, err := c.ListDevicesWithContext(ctx, authyUserID)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, d := range devices {
+		if d.HasApp() {
+			n++
+		}
+	}
+	return n, nil
 }
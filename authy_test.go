@@ -1,9 +1,20 @@
 package authy
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jarcoal/httpmock"
 )
@@ -17,7 +28,7 @@ func setup() {
 	app = App{
 		ApiSecret: "verysecret",
 	}
-	client = NewClient(app)
+	client, _ = NewClient(app)
 	httpmock.ActivateNonDefault(client.Client)
 }
 
@@ -26,7 +37,11 @@ func teardown() {
 }
 
 func TestNewClient(t *testing.T) {
-	testClient := NewClient(app)
+	app := App{ApiSecret: "verysecret"}
+	testClient, err := NewClient(app)
+	if err != nil {
+		t.Fatalf("NewClient err = %v, expected nil", err)
+	}
 	expected := "https://api.authy.com/protected/json/"
 	if app.ApiFormat != "" {
 		expected = "https://api.authy.com/protected/xml/"
@@ -35,13 +50,49 @@ func TestNewClient(t *testing.T) {
 	if testClient.baseURL.String() != expected {
 		t.Errorf("NewClient BaseURL = %v, expected %v", testClient.baseURL.String(), expected)
 	}
+
+	for _, secret := range []string{"", " verysecret", "verysecret\n", "very secret"} {
+		if _, err := NewClient(App{ApiSecret: secret}); err == nil {
+			t.Errorf("NewClient(%q) returned nil error", secret)
+		}
+	}
+
+	for format, expected := range map[string]string{
+		"XML":  "https://api.authy.com/protected/xml/",
+		"Json": "https://api.authy.com/protected/json/",
+	} {
+		c, err := NewClient(App{ApiSecret: "verysecret", ApiFormat: format})
+		if err != nil {
+			t.Fatalf("NewClient with format %q err = %v, expected nil", format, err)
+		}
+		if c.baseURL.String() != expected {
+			t.Errorf("NewClient with format %q BaseURL = %v, expected %v", format, c.baseURL, expected)
+		}
+	}
+	if _, err := NewClient(App{ApiSecret: "verysecret", ApiFormat: "yaml"}); err == nil {
+		t.Errorf("NewClient with format yaml returned nil error")
+	}
+}
+
+func TestNewClientWarnsOnUnusualKey(t *testing.T) {
+	var buf bytes.Buffer
+	NewClient(App{ApiSecret: "verysecret"}, WithLogger(log.New(&buf, "", 0)))
+	if !strings.Contains(buf.String(), "api secret is not 32 alphanumeric characters") {
+		t.Errorf("NewClient logged %q, expected a warning about the api secret", buf.String())
+	}
+
+	buf.Reset()
+	NewClient(App{ApiSecret: "0123456789abcdefABCDEF0123456789"}, WithLogger(log.New(&buf, "", 0)))
+	if buf.Len() != 0 {
+		t.Errorf("NewClient logged %q for a well formed api secret", buf.String())
+	}
 }
 
 func TestNewRequest(t *testing.T) {
 	setup()
 	defer teardown()
 
-	testClient := NewClient(app)
+	testClient, _ := NewClient(app)
 
 	apiFormat := "json"
 	if app.ApiFormat != "" {
@@ -82,6 +133,57 @@ func TestNewRequest(t *testing.T) {
 	}
 }
 
+func TestContextWithAPIKey(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var keys []string
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/sms/12345",
+		func(req *http.Request) (*http.Response, error) {
+			keys = append(keys, req.Header.Get("X-Authy-API-Key"))
+			return httpmock.NewStringResponse(200, `{"success": true}`), nil
+		})
+
+	client.SendOTPWithContext(ContextWithAPIKey(context.Background(), "brandkey"), 12345)
+	client.SendOTP(12345)
+
+	if len(keys) != 2 || keys[0] != "brandkey" || keys[1] != "verysecret" {
+		t.Errorf("X-Authy-API-Key headers = %v, expected [brandkey verysecret]", keys)
+	}
+}
+
+func TestDo(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var header http.Header
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/12345/status",
+		func(req *http.Request) (*http.Response, error) {
+			header = req.Header
+			resp := httpmock.NewStringResponse(404, `{"message": "User not found.", "success": false}`)
+			resp.Header.Set("X-RateLimit-Remaining", "9")
+			return resp, nil
+		})
+
+	req, _ := http.NewRequest("GET", "users/12345/status", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do err = %v, expected nil", err)
+	}
+	defer resp.Body.Close()
+
+	if header.Get("X-Authy-API-Key") != "verysecret" || header.Get("User-Agent") != defaultUserAgent {
+		t.Errorf("Do request headers = %v, expected api key and user agent", header)
+	}
+	if resp.StatusCode != 404 || resp.Header.Get("X-RateLimit-Remaining") != "9" {
+		t.Errorf("Do response = %d %v, expected 404 with rate limit header", resp.StatusCode, resp.Header)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != `{"message": "User not found.", "success": false}` {
+		t.Errorf("Do body = %s, expected raw response body", body)
+	}
+}
+
 func TestCheckOTPToken(t *testing.T) {
 	setup()
 	defer teardown()
@@ -94,7 +196,7 @@ func TestCheckOTPToken(t *testing.T) {
 	}{
 		{
 			1234567,
-			"atokenforyou",
+			"1234567",
 			httpmock.NewStringResponder(200,
 				`{
 							"message": "Token is valid.", 
@@ -105,7 +207,7 @@ func TestCheckOTPToken(t *testing.T) {
 		},
 		{
 			1234567,
-			"abadtokenforyou",
+			"7654321",
 			httpmock.NewStringResponder(401, `
 				{
 					{
@@ -241,6 +343,19 @@ func TestCreateUser(t *testing.T) {
 				"success":false, 
 			}`),
 			0,
+		}, {
+			AuthyUser{
+				Cellphone:   "not a number",
+				CountryCode: "61",
+			},
+			httpmock.NewStringResponder(201, `
+						{
+						"success":true, 
+						"user":{
+							"id":12345
+							}
+						}`),
+			0,
 		},
 	}
 
@@ -258,3 +373,991 @@ func TestCreateUser(t *testing.T) {
 
 	}
 }
+
+func TestCreateUserEmail(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var email string
+	httpmock.RegisterResponder("POST", "https://api.authy.com/protected/json/users/new",
+		func(req *http.Request) (*http.Response, error) {
+			req.ParseForm()
+			email = req.PostForm.Get("user[email]")
+			return httpmock.NewStringResponse(200, `{"success": true, "user": {"id": 12345}}`), nil
+		})
+
+	_, err := client.CreateUser(AuthyUser{Email: " jo@example.com\n", Cellphone: "111111111", CountryCode: "61"})
+	if err != nil {
+		t.Fatalf("CreateUser err = %v, expected nil", err)
+	}
+	if email != "jo@example.com" {
+		t.Errorf("CreateUser sent email %q, expected %q", email, "jo@example.com")
+	}
+
+	_, err = client.CreateUser(AuthyUser{Email: "not an email", Cellphone: "111111111", CountryCode: "61"})
+	if err == nil {
+		t.Errorf("CreateUser with invalid email returned nil error")
+	}
+}
+
+func TestAuthyUserJSON(t *testing.T) {
+	au := AuthyUser{Email: "bob@example.com", Cellphone: "412345678", CountryCode: "61", SendInstallLink: true}
+	b, err := json.Marshal(au)
+	if err != nil {
+		t.Fatalf("json.Marshal AuthyUser err = %v", err)
+	}
+	expected := `{"email":"bob@example.com","cellphone":"412345678","country_code":"61","send_install_link_via_sms":true}`
+	if string(b) != expected {
+		t.Errorf("json.Marshal AuthyUser = %s, expected %s", b, expected)
+	}
+
+	var decoded AuthyUser
+	if err := json.Unmarshal(b, &decoded); err != nil || decoded != au {
+		t.Errorf("json.Unmarshal AuthyUser = %+v, %v, expected %+v", decoded, err, au)
+	}
+}
+
+func TestSendOTPWithContextCancelled(t *testing.T) {
+	setup()
+	defer teardown()
+
+	url := "https://api.authy.com/protected/json/sms/12334566"
+	httpmock.RegisterResponder("GET", url, func(req *http.Request) (*http.Response, error) {
+		if err := req.Context().Err(); err != nil {
+			return nil, err
+		}
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := client.SendOTPWithContext(ctx, 12334566)
+	if err == nil {
+		t.Errorf("SendOTPWithContext with cancelled context returned nil error")
+	}
+}
+
+func TestResponseMessageStatusCode(t *testing.T) {
+	setup()
+	defer teardown()
+
+	cases := []struct {
+		status int
+		body   string
+	}{
+		{200, `{"success": true}`},
+		{429, `{"success": false, "message": "Too many requests"}`},
+		{500, `<html>Internal Server Error</html>`},
+	}
+
+	for _, c := range cases {
+		url := "https://api.authy.com/protected/json/users/12334566/status"
+		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(c.status, c.body))
+
+		msg := new(ResponseMessage)
+		client.Get("users/12334566/status", msg)
+		if msg.StatusCode != c.status {
+			t.Errorf("UserStatus StatusCode = %v, expected %v", msg.StatusCode, c.status)
+		}
+		if string(msg.RawBody) != c.body {
+			t.Errorf("UserStatus RawBody = %v, expected %v", string(msg.RawBody), c.body)
+		}
+	}
+}
+
+func TestGetAPIError(t *testing.T) {
+	setup()
+	defer teardown()
+
+	cases := []struct {
+		status   int
+		body     string
+		expected *APIError
+	}{
+		{200, `{"success": true}`, nil},
+		{401, `{"success": false, "message": "Invalid API key"}`, &APIError{StatusCode: 401, Message: "Invalid API key"}},
+		{500, `<html>Internal Server Error</html>`, &APIError{StatusCode: 500}},
+	}
+
+	for _, c := range cases {
+		httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/app/details",
+			httpmock.NewStringResponder(c.status, c.body))
+
+		_, err := client.GetAppInfo()
+		if c.expected == nil {
+			if err != nil {
+				t.Errorf("GetAppInfo err = %v, expected nil", err)
+			}
+			continue
+		}
+
+		apiErr, ok := err.(*APIError)
+		if !ok {
+			t.Errorf("GetAppInfo err = %v, expected *APIError", err)
+			continue
+		}
+		if !reflect.DeepEqual(apiErr, c.expected) {
+			t.Errorf("GetAppInfo err = %+v, expected %+v", apiErr, c.expected)
+		}
+	}
+}
+
+func TestSendOTPWithAction(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var rawQuery string
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/sms/12334566",
+		func(req *http.Request) (*http.Response, error) {
+			rawQuery = req.URL.RawQuery
+			return httpmock.NewStringResponse(200, `{"success": true}`), nil
+		})
+
+	cases := []struct {
+		action        string
+		actionMessage string
+		expected      string
+	}{
+		{"", "", ""},
+		{"", "ignored without an action", ""},
+		{"login", "", "action=login"},
+		{"login", "Login to Acme & Co? 100%", "action=login&action_message=Login+to+Acme+%26+Co%3F+100%25"},
+	}
+
+	for _, c := range cases {
+		rawQuery = ""
+		_, err := client.SendOTPWithAction(12334566, c.action, c.actionMessage)
+		if err != nil {
+			t.Fatalf("SendOTPWithAction(%q, %q) err = %v, expected nil", c.action, c.actionMessage, err)
+		}
+		if rawQuery != c.expected {
+			t.Errorf("SendOTPWithAction(%q, %q) query = %v, expected %v", c.action, c.actionMessage, rawQuery, c.expected)
+		}
+	}
+}
+
+func TestSendOTPWithOptions(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var calledURL string
+	responder := func(req *http.Request) (*http.Response, error) {
+		calledURL = req.URL.String()
+		return httpmock.NewStringResponse(200, `{"success": true}`), nil
+	}
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/sms/12334566", responder)
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/call/12334566", responder)
+
+	cases := []struct {
+		opts     OTPOptions
+		expected string
+	}{
+		{OTPOptions{}, "https://api.authy.com/protected/json/sms/12334566"},
+		{OTPOptions{Via: DeliverySMS}, "https://api.authy.com/protected/json/sms/12334566"},
+		{OTPOptions{Via: DeliveryCall}, "https://api.authy.com/protected/json/call/12334566"},
+		{OTPOptions{Via: DeliveryCall, Action: "login", ActionMessage: "hi there"},
+			"https://api.authy.com/protected/json/call/12334566?action=login&action_message=hi+there"},
+		{OTPOptions{Force: true}, "https://api.authy.com/protected/json/sms/12334566?force=true"},
+		{OTPOptions{Via: DeliveryCall, Force: true}, "https://api.authy.com/protected/json/call/12334566?force=true"},
+		{OTPOptions{Locale: "es"}, "https://api.authy.com/protected/json/sms/12334566?locale=es"},
+		{OTPOptions{Via: DeliveryCall, Action: "login", Locale: "pt-BR&x"},
+			"https://api.authy.com/protected/json/call/12334566?action=login&locale=pt-BR%26x"},
+	}
+
+	for _, c := range cases {
+		calledURL = ""
+		msg, err := client.SendOTPWithOptions(12334566, c.opts)
+		if err != nil {
+			t.Fatalf("SendOTPWithOptions(%+v) err = %v, expected nil", c.opts, err)
+		}
+		if !msg.Success {
+			t.Errorf("SendOTPWithOptions(%+v) Success = false, expected true", c.opts)
+		}
+		if calledURL != c.expected {
+			t.Errorf("SendOTPWithOptions(%+v) URL = %v, expected %v", c.opts, calledURL, c.expected)
+		}
+	}
+
+	if _, err := client.SendOTPWithOptions(12334566, OTPOptions{Via: "email"}); err == nil {
+		t.Errorf("SendOTPWithOptions with unsupported delivery returned nil error")
+	}
+	if _, err := client.SendOTPWithOptions(12334566, OTPOptions{TokenLength: 8}); err == nil {
+		t.Errorf("SendOTPWithOptions with TokenLength returned nil error")
+	}
+}
+
+func TestTypedResults(t *testing.T) {
+	setup()
+	defer teardown()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/sms/12345",
+		httpmock.NewStringResponder(200, `{"message": "Ignored: SMS is not needed for smartphones.", "cellphone": "+1-XXX-XXX-XX34", "ignored": true, "success": true}`))
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/12345/status",
+		httpmock.NewStringResponder(200, `{"status": {"authy_id": 12345, "registered": true, "confirmed": true, "country_code": 1, "devices": ["iphone"]}, "success": true}`))
+
+	sent, err := client.SendOTPWithResult(12345, OTPOptions{})
+	expected := SentOTP{Message: "Ignored: SMS is not needed for smartphones.", Cellphone: "+1-XXX-XXX-XX34", Ignored: true}
+	if err != nil || *sent != expected {
+		t.Errorf("SendOTPWithResult = %+v, %v, expected %+v", sent, err, expected)
+	}
+
+	status, err := client.UserDetails(12345)
+	if err != nil || status.AuthyID != 12345 || !status.RegistrationComplete() || status.CountryCode != 1 || len(status.Devices) != 1 {
+		t.Errorf("UserDetails = %+v, %v", status, err)
+	}
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/sms/12345",
+		httpmock.NewStringResponder(503, ``))
+	if sent, err := client.SendOTPWithResult(12345, OTPOptions{}); sent != nil || err == nil {
+		t.Errorf("SendOTPWithResult = %+v, %v, expected error", sent, err)
+	}
+}
+
+func TestXMLFormat(t *testing.T) {
+	xmlClient, _ := NewClient(App{ApiSecret: "verysecret", ApiFormat: "xml"})
+	httpmock.ActivateNonDefault(xmlClient.Client)
+	defer httpmock.DeactivateAndReset()
+
+	var accept string
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/xml/users/12345/status",
+		func(req *http.Request) (*http.Response, error) {
+			accept = req.Header.Get("Accept")
+			resp := httpmock.NewStringResponse(200, `<?xml version="1.0" encoding="UTF-8"?>
+				<hash>
+					<message>User status.</message>
+					<status>
+						<authy_id type="integer">12345</authy_id>
+						<confirmed type="boolean">true</confirmed>
+						<registered type="boolean">false</registered>
+						<country_code type="integer">61</country_code>
+						<phone_number>XXX-XXX-1111</phone_number>
+					</status>
+					<success type="boolean">true</success>
+				</hash>`)
+			resp.Header.Set("Content-Type", "application/xml; charset=utf-8")
+			return resp, nil
+		})
+
+	msg, err := xmlClient.UserStatus(12345)
+	if err != nil {
+		t.Fatalf("UserStatus err = %v, expected nil", err)
+	}
+
+	if accept != "application/xml" {
+		t.Errorf("UserStatus Accept = %v, expected application/xml", accept)
+	}
+	if !msg.Success || msg.Message != "User status." {
+		t.Errorf("UserStatus got %+v", msg)
+	}
+	if msg.Status.AuthyID != 12345 || !msg.Status.Confirmed || msg.Status.CountryCode != 61 || msg.Status.PhoneNumber != "XXX-XXX-1111" {
+		t.Errorf("UserStatus Status = %+v", msg.Status)
+	}
+}
+
+func TestVerifyResponseUnmarshalJSON(t *testing.T) {
+	cases := []struct {
+		body     string
+		expected verifyResponse
+	}{
+		{`{"message": "Token is valid.", "token": "is valid", "success": "true"}`,
+			verifyResponse{Success: true, Token: "is valid", Message: "Token is valid."}},
+		{`{"message": "Token is valid.", "token": "is valid", "success": true}`,
+			verifyResponse{Success: true, Token: "is valid", Message: "Token is valid."}},
+		{`{"message": "Token is invalid", "token": "is invalid", "success": false}`,
+			verifyResponse{Success: false, Token: "is invalid", Message: "Token is invalid"}},
+		{`{"message": "Token is invalid", "success": "false"}`,
+			verifyResponse{Success: false, Message: "Token is invalid"}},
+	}
+
+	for _, c := range cases {
+		var v verifyResponse
+		if err := json.Unmarshal([]byte(c.body), &v); err != nil {
+			t.Errorf("verifyResponse.UnmarshalJSON(%v) err = %v, expected nil", c.body, err)
+			continue
+		}
+		if v != c.expected {
+			t.Errorf("verifyResponse.UnmarshalJSON(%v) = %+v, expected %+v", c.body, v, c.expected)
+		}
+	}
+}
+
+func TestCheckOTPTokenInvalid(t *testing.T) {
+	setup()
+	defer teardown()
+
+	cases := []struct {
+		responder httpmock.Responder
+		expected  bool
+		err       bool
+	}{
+		{httpmock.NewStringResponder(200, `{"message": "Token is valid.", "token": "is valid", "success": true}`), true, false},
+		{httpmock.NewStringResponder(401, `{"message": "Token is invalid", "token": "is invalid", "success": false}`), false, false},
+		{httpmock.NewStringResponder(500, ``), false, true},
+	}
+
+	for _, c := range cases {
+		httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/verify/1234567/12345", c.responder)
+
+		valid, err := client.CheckOTPToken(12345, "1234567")
+		if valid != c.expected {
+			t.Errorf("CheckOTPToken got %v, expected %v", valid, c.expected)
+		}
+		if (err != nil) != c.err {
+			t.Errorf("CheckOTPToken err = %v, expected error %v", err, c.err)
+		}
+	}
+}
+
+func TestCheckOTPTokenWithOptions(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var rawQuery string
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/verify/1234567/12345",
+		func(req *http.Request) (*http.Response, error) {
+			rawQuery = req.URL.RawQuery
+			return httpmock.NewStringResponse(200, `{"token": "is valid", "success": "true"}`), nil
+		})
+
+	cases := []struct {
+		opts     VerifyOptions
+		expected string
+	}{
+		{VerifyOptions{}, ""},
+		{VerifyOptions{Force: true}, "force=true"},
+	}
+
+	for _, c := range cases {
+		valid, err := client.CheckOTPTokenWithOptions(12345, "1234567", c.opts)
+		if err != nil || !valid {
+			t.Errorf("CheckOTPTokenWithOptions(%+v) = %v, %v expected true, nil", c.opts, valid, err)
+		}
+		if rawQuery != c.expected {
+			t.Errorf("CheckOTPTokenWithOptions(%+v) query = %v, expected %v", c.opts, rawQuery, c.expected)
+		}
+	}
+}
+
+func TestVerifyOTPToken(t *testing.T) {
+	setup()
+	defer teardown()
+
+	cases := []struct {
+		responder httpmock.Responder
+		expected  VerificationResult
+	}{
+		{httpmock.NewStringResponder(200, `{"message": "Token is valid.", "token": "is valid", "success": "true"}`),
+			VerificationResult{Valid: true, Message: "Token is valid."}},
+		{httpmock.NewStringResponder(401, `{"message": "Token is invalid", "token": "is invalid", "success": false, "error_code": "60020"}`),
+			VerificationResult{Reason: ReasonInvalid, Message: "Token is invalid", ErrorCode: "60020"}},
+		{httpmock.NewStringResponder(401, `{"message": "Token is invalid. Token was used recently", "success": false}`),
+			VerificationResult{Reason: ReasonUsedRecently, Message: "Token is invalid. Token was used recently"}},
+		{httpmock.NewStringResponder(401, `{"message": "Token has expired", "success": false}`),
+			VerificationResult{Reason: ReasonExpired, Message: "Token has expired"}},
+		{httpmock.NewStringResponder(401, `{"message": "You have reached the maximum number of attempts", "success": false, "error_code": "60003"}`),
+			VerificationResult{Reason: ReasonTooManyAttempts, Message: "You have reached the maximum number of attempts", ErrorCode: "60003"}},
+	}
+
+	for _, c := range cases {
+		httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/verify/1234567/12345", c.responder)
+
+		result, err := client.VerifyOTPToken(12345, "1234567")
+		if err != nil {
+			t.Fatalf("VerifyOTPToken err = %v, expected nil", err)
+		}
+		if *result != c.expected {
+			t.Errorf("VerifyOTPToken = %+v, expected %+v", result, c.expected)
+		}
+	}
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/verify/1234567/12345",
+		httpmock.NewStringResponder(500, ``))
+	if _, err := client.VerifyOTPToken(12345, "1234567"); err == nil {
+		t.Errorf("VerifyOTPToken with server error returned nil error")
+	}
+}
+
+func TestVerifyOTPTokenInvalidAPIKey(t *testing.T) {
+	setup()
+	defer teardown()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/verify/1234567/12345",
+		httpmock.NewStringResponder(401, `{"message": "Invalid API key", "error_code": "60001", "success": false}`))
+
+	valid, err := client.CheckOTPToken(12345, "1234567")
+	var apiErr *APIError
+	if valid || !errors.As(err, &apiErr) || apiErr.Code != "60001" {
+		t.Errorf("CheckOTPToken with an invalid api key = %v, %v, expected *APIError with code 60001", valid, err)
+	}
+	if result, err := client.VerifyOTPToken(12345, "1234567"); result != nil || !errors.As(err, &apiErr) {
+		t.Errorf("VerifyOTPToken with an invalid api key = %+v, %v, expected *APIError", result, err)
+	}
+	if _, err := client.CheckOTPTokenForAny([]int64{12345}, "1234567"); errors.Is(err, ErrUserNotFound) || !errors.As(err, &apiErr) {
+		t.Errorf("CheckOTPTokenForAny with an invalid api key err = %v, expected *APIError", err)
+	}
+}
+
+func TestVerifyOTPTokenOrResend(t *testing.T) {
+	setup()
+	defer teardown()
+
+	sent := 0
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/sms/12345",
+		func(req *http.Request) (*http.Response, error) {
+			sent++
+			return httpmock.NewStringResponse(200, `{"success": true}`), nil
+		})
+
+	cases := []struct {
+		body   string
+		resend bool
+		reason VerificationReason
+		resent bool
+	}{
+		{`{"message": "Token has expired", "success": false}`, true, ReasonExpired, true},
+		{`{"message": "Token is invalid. Token was used recently", "success": false}`, true, ReasonUsedRecently, true},
+		{`{"message": "Token has expired", "success": false}`, false, ReasonExpired, false},
+		{`{"message": "Token is invalid", "success": false}`, true, ReasonInvalid, false},
+	}
+
+	for i, c := range cases {
+		sent = 0
+		httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/verify/123456/12345",
+			httpmock.NewStringResponder(401, c.body))
+
+		result, err := client.VerifyOTPTokenOrResend(12345, "123456", c.resend)
+		if err != nil {
+			t.Fatalf("%d: VerifyOTPTokenOrResend err = %v, expected nil", i, err)
+		}
+		if result.Reason != c.reason || result.Resent != c.resent {
+			t.Errorf("%d: VerifyOTPTokenOrResend = %+v, expected reason %v and resent %v", i, result, c.reason, c.resent)
+		}
+		if resent := sent == 1; resent != c.resent {
+			t.Errorf("%d: VerifyOTPTokenOrResend sent %d tokens, expected resent %v", i, sent, c.resent)
+		}
+	}
+}
+
+func TestVerifyAppToken(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var calledURL string
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/verify/123456/12345",
+		func(req *http.Request) (*http.Response, error) {
+			calledURL = req.URL.String()
+			return httpmock.NewStringResponse(401, `{"message": "Token is invalid. Token was used recently", "success": false}`), nil
+		})
+
+	result, err := client.VerifyAppToken(12345, "123456")
+	if err != nil {
+		t.Fatalf("VerifyAppToken err = %v, expected nil", err)
+	}
+	if result.Valid || result.Reason != ReasonUsedRecently {
+		t.Errorf("VerifyAppToken = %+v, expected used recently", result)
+	}
+	if expected := "https://api.authy.com/protected/json/verify/123456/12345?force=true"; calledURL != expected {
+		t.Errorf("VerifyAppToken URL = %v, expected %v", calledURL, expected)
+	}
+}
+
+func TestRegisterActivity(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var sent string
+	httpmock.RegisterResponder("POST", "https://api.authy.com/protected/json/users/12345/register_activity",
+		func(req *http.Request) (*http.Response, error) {
+			b, _ := ioutil.ReadAll(req.Body)
+			sent = string(b)
+			return httpmock.NewStringResponse(200, `{"message": "Activity was created.", "success": true}`), nil
+		})
+
+	err := client.RegisterActivity(12345, ActivityPasswordReset, map[string]string{"reset_by": "support"})
+	if err != nil {
+		t.Fatalf("RegisterActivity err = %v, expected nil", err)
+	}
+
+	expected := "data%5Breset_by%5D=support&type=password_reset"
+	if sent != expected {
+		t.Errorf("RegisterActivity Body = %v, expected %v", sent, expected)
+	}
+
+	if err := client.RegisterActivity(12345, "logged_out", nil); err == nil {
+		t.Errorf("RegisterActivity with unknown type returned nil error")
+	}
+}
+
+func TestGetRegistrationQR(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var sent string
+	httpmock.RegisterResponder("POST", "https://api.authy.com/protected/json/users/12345/secret",
+		func(req *http.Request) (*http.Response, error) {
+			b, _ := ioutil.ReadAll(req.Body)
+			sent = string(b)
+			return httpmock.NewStringResponse(200, `
+				{
+					"label": "Acme:bob",
+					"issuer": "Acme",
+					"qr_code": "https://api.authy.com/qr/12345.png",
+					"success": true
+				}`), nil
+		})
+
+	qr, err := client.GetRegistrationQR(12345, "Acme:bob", 300)
+	if err != nil {
+		t.Fatalf("GetRegistrationQR err = %v, expected nil", err)
+	}
+	if qr != "https://api.authy.com/qr/12345.png" {
+		t.Errorf("GetRegistrationQR = %v", qr)
+	}
+
+	expected := "label=Acme%3Abob&qr_size=300"
+	if sent != expected {
+		t.Errorf("GetRegistrationQR Body = %v, expected %v", sent, expected)
+	}
+}
+
+func TestCreateUserDetailed(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var sent string
+	httpmock.RegisterResponder("POST", "https://api.authy.com/protected/json/users/new",
+		func(req *http.Request) (*http.Response, error) {
+			b, _ := ioutil.ReadAll(req.Body)
+			sent = string(b)
+			return httpmock.NewStringResponse(200, `
+				{
+					"message": "User created successfully.",
+					"cellphone": "+61-XXX-XXX-X111",
+					"user": {"id": 12345},
+					"success": true
+				}`), nil
+		})
+
+	msg, err := client.CreateUserDetailed(AuthyUser{
+		Cellphone:       "111111111",
+		CountryCode:     "61",
+		SendInstallLink: true,
+	})
+	if err != nil {
+		t.Fatalf("CreateUserDetailed err = %v, expected nil", err)
+	}
+
+	expected := "send_install_link_via_sms=true&user%5Bcellphone%5D=111111111&user%5Bcountry_code%5D=61"
+	if sent != expected {
+		t.Errorf("CreateUserDetailed Body = %v, expected %v", sent, expected)
+	}
+	if msg.User.ID != 12345 || msg.Cellphone != "+61-XXX-XXX-X111" || msg.Message != "User created successfully." {
+		t.Errorf("CreateUserDetailed got %+v", msg)
+	}
+}
+
+func TestRemoveUser(t *testing.T) {
+	setup()
+	defer teardown()
+
+	cases := []struct {
+		responder httpmock.Responder
+		err       bool
+	}{
+		{httpmock.NewStringResponder(200, `{"message": "User was added to remove.", "success": true}`), false},
+		{httpmock.NewStringResponder(200, `{"message": "User not found.", "success": false}`), true},
+		{httpmock.NewStringResponder(200, ``), true},
+		{httpmock.NewStringResponder(404, ``), true},
+		{httpmock.NewStringResponder(500, `{"success": true}`), true},
+	}
+
+	for i, c := range cases {
+		httpmock.RegisterResponder("POST", "https://api.authy.com/protected/json/users/12345/remove", c.responder)
+
+		if err := client.RemoveUser(12345); (err != nil) != c.err {
+			t.Errorf("%d: RemoveUser err = %v, expected error %v", i, err, c.err)
+		}
+	}
+}
+
+func TestRemoveUserAndWait(t *testing.T) {
+	setup()
+	defer teardown()
+
+	removePollInterval = time.Millisecond
+	defer func() { removePollInterval = time.Second }()
+
+	httpmock.RegisterResponder("POST", "https://api.authy.com/protected/json/users/12345/remove",
+		httpmock.NewStringResponder(200, `{"message": "User was added to remove.", "success": true}`))
+
+	polls := 0
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/12345/status",
+		func(req *http.Request) (*http.Response, error) {
+			polls++
+			if polls < 3 {
+				return httpmock.NewStringResponse(200, `{"status": {"authy_id": 12345, "registered": true}, "success": true}`), nil
+			}
+			return httpmock.NewStringResponse(404, `{"message": "User not found.", "success": false}`), nil
+		})
+
+	if err := client.RemoveUserAndWait(context.Background(), 12345); err != nil {
+		t.Errorf("RemoveUserAndWait err = %v, expected nil", err)
+	}
+	if polls != 3 {
+		t.Errorf("RemoveUserAndWait polled %d times, expected 3", polls)
+	}
+
+	// a user without the app isn't registered but still exists
+	polls = 0
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/12345/status",
+		func(req *http.Request) (*http.Response, error) {
+			polls++
+			if polls < 3 {
+				return httpmock.NewStringResponse(200, `{"status": {"authy_id": 12345, "registered": false, "devices": ["sms"]}, "success": true}`), nil
+			}
+			return httpmock.NewStringResponse(404, `{"message": "User not found.", "error_code": "60026", "success": false}`), nil
+		})
+	if err := client.RemoveUserAndWait(context.Background(), 12345); err != nil {
+		t.Errorf("RemoveUserAndWait for SMS only user err = %v, expected nil", err)
+	}
+	if polls != 3 {
+		t.Errorf("RemoveUserAndWait for SMS only user polled %d times, expected 3", polls)
+	}
+
+	// failures that may pass are polled through
+	polls = 0
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/12345/status",
+		func(req *http.Request) (*http.Response, error) {
+			polls++
+			switch polls {
+			case 1:
+				return nil, errors.New("connection reset")
+			case 2:
+				return httpmock.NewStringResponse(503, `{"message": "Service unavailable", "success": false}`), nil
+			case 3:
+				return httpmock.NewStringResponse(429, `{"message": "Too many requests", "success": false}`), nil
+			}
+			return httpmock.NewStringResponse(404, `{"message": "User not found.", "error_code": "60026", "success": false}`), nil
+		})
+	if err := client.RemoveUserAndWait(context.Background(), 12345); err != nil {
+		t.Errorf("RemoveUserAndWait after failed polls err = %v, expected nil", err)
+	}
+	if polls != 4 {
+		t.Errorf("RemoveUserAndWait after failed polls polled %d times, expected 4", polls)
+	}
+
+	// a rejected request won't succeed by polling again
+	polls = 0
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/12345/status",
+		func(req *http.Request) (*http.Response, error) {
+			polls++
+			return httpmock.NewStringResponse(401, `{"message": "Invalid API key", "error_code": "60001", "success": false}`), nil
+		})
+	err := client.RemoveUserAndWait(context.Background(), 12345)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 401 || polls != 1 {
+		t.Errorf("RemoveUserAndWait = %v after %d polls, expected *APIError with status 401 after 1", err, polls)
+	}
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/12345/status",
+		httpmock.NewStringResponder(200, `{"status": {"authy_id": 12345, "registered": false}, "success": true}`))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	err = client.RemoveUserAndWait(ctx, 12345)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RemoveUserAndWait err = %v, expected context.DeadlineExceeded", err)
+	}
+}
+
+func TestUserRegistrationStatus(t *testing.T) {
+	setup()
+	defer teardown()
+
+	cases := []struct {
+		body     string
+		expected UserRegistration
+	}{
+		{`{"status": {"authy_id": 12345, "confirmed": true, "registered": true, "devices": ["android", "sms"]}, "success": true}`,
+			UserRegistration{State: Confirmed, DeviceCount: 2}},
+		{`{"status": {"authy_id": 12345, "confirmed": false, "registered": true, "devices": ["iphone"]}, "success": true}`,
+			UserRegistration{State: Pending, DeviceCount: 1}},
+		{`{"status": {"authy_id": 12345, "confirmed": true, "registered": false, "devices": ["sms"]}, "success": true}`,
+			UserRegistration{State: Pending, DeviceCount: 1}},
+		{`{"status": {"authy_id": 12345, "confirmed": false, "registered": false, "devices": []}, "success": true}`,
+			UserRegistration{State: Unregistered}},
+	}
+
+	for _, c := range cases {
+		httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/12345/status",
+			httpmock.NewStringResponder(200, c.body))
+
+		reg, err := client.UserRegistrationStatus(12345)
+		if err != nil {
+			t.Fatalf("UserRegistrationStatus err = %v, expected nil", err)
+		}
+		if *reg != c.expected {
+			t.Errorf("UserRegistrationStatus = %+v, expected %+v", reg, c.expected)
+		}
+	}
+}
+
+func TestStatusPhone(t *testing.T) {
+	cases := []struct {
+		status      Status
+		masked      bool
+		lastDigits  string
+		callingCode string
+	}{
+		{Status{CountryCode: 61, PhoneNumber: "XXX-XXX-1234"}, true, "1234", "+61"},
+		{Status{CountryCode: 1, PhoneNumber: "555-555-1234"}, false, "1234", "+1"},
+		{Status{}, false, "", ""},
+	}
+
+	for _, c := range cases {
+		if masked := c.status.PhoneMasked(); masked != c.masked {
+			t.Errorf("%+v PhoneMasked = %v, expected %v", c.status, masked, c.masked)
+		}
+		if digits := c.status.PhoneLastDigits(); digits != c.lastDigits {
+			t.Errorf("%+v PhoneLastDigits = %q, expected %q", c.status, digits, c.lastDigits)
+		}
+		if code := c.status.CountryCallingCode(); code != c.callingCode {
+			t.Errorf("%+v CountryCallingCode = %q, expected %q", c.status, code, c.callingCode)
+		}
+	}
+}
+
+func TestUserStatusDevices(t *testing.T) {
+	setup()
+	defer teardown()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/12345/status",
+		httpmock.NewStringResponder(200, `
+			{
+				"status": {
+					"authy_id": 12345,
+					"confirmed": true,
+					"registered": true,
+					"devices": [
+						{
+							"id": 1,
+							"os_type": "android",
+							"registration_date": "2019-07-07T23:22:24Z",
+							"registration_method": "push",
+							"registration_region": "New South Wales",
+							"registration_city": "Sydney",
+							"country": "Australia",
+							"region": "New South Wales",
+							"city": "Sydney",
+							"ip": "10.0.0.1",
+							"last_sync_date": "2019-07-08T01:00:00Z"
+						},
+						"sms"
+					]
+				},
+				"success": true
+			}`))
+
+	msg, err := client.UserStatus(12345)
+	if err != nil {
+		t.Fatalf("UserStatus err = %v, expected nil", err)
+	}
+
+	devices := msg.Status.Devices
+	if len(devices) != 2 {
+		t.Fatalf("UserStatus returned %d devices, expected 2", len(devices))
+	}
+	d := devices[0]
+	if d.ID != 1 || *d.OSType != "android" || *d.RegistrationCity != "Sydney" || *d.IP != "10.0.0.1" || d.LastAccountRecoveryAt != nil {
+		t.Errorf("UserStatus Devices[0] = %+v", d)
+	}
+	if *devices[1].OSType != "sms" {
+		t.Errorf("UserStatus Devices[1].OSType = %v, expected sms", *devices[1].OSType)
+	}
+}
+
+func TestUserStatusTwoDevices(t *testing.T) {
+	setup()
+	defer teardown()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/12345/status",
+		httpmock.NewStringResponder(200, `
+			{
+				"status": {
+					"authy_id": 12345,
+					"devices": [
+						{"id": 1, "os_type": "android", "city": "Sydney"},
+						{"id": 2, "os_type": "ios", "city": "Melbourne"}
+					]
+				},
+				"success": true
+			}`))
+
+	msg, err := client.UserStatus(12345)
+	if err != nil {
+		t.Fatalf("UserStatus err = %v, expected nil", err)
+	}
+
+	expected := []struct {
+		id     int64
+		osType string
+		city   string
+	}{
+		{1, "android", "Sydney"},
+		{2, "ios", "Melbourne"},
+	}
+	if len(msg.Status.Devices) != len(expected) {
+		t.Fatalf("UserStatus returned %d devices, expected %d", len(msg.Status.Devices), len(expected))
+	}
+	for i, e := range expected {
+		d := msg.Status.Devices[i]
+		if d.ID != e.id || *d.OSType != e.osType || *d.City != e.city {
+			t.Errorf("UserStatus Devices[%d] = %+v, expected %+v", i, d, e)
+		}
+	}
+}
+
+func TestListDevices(t *testing.T) {
+	setup()
+	defer teardown()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/12345/status",
+		httpmock.NewStringResponder(200, `
+			{
+				"status": {
+					"authy_id": 12345,
+					"devices": [
+						{"id": 1, "os_type": "android", "registration_date": 1490996931},
+						{"id": 2, "os_type": "ios", "registration_date": "2019-07-07T23:22:24Z"},
+						"sms"
+					]
+				},
+				"success": true
+			}`))
+
+	devices, err := client.ListDevices(12345)
+	if err != nil {
+		t.Fatalf("ListDevices err = %v, expected nil", err)
+	}
+	if len(devices) != 3 {
+		t.Fatalf("ListDevices returned %d devices, expected 3", len(devices))
+	}
+
+	expected := []time.Time{
+		time.Unix(1490996931, 0).UTC(),
+		time.Date(2019, 7, 7, 23, 22, 24, 0, time.UTC),
+	}
+	for i, e := range expected {
+		if at, ok := devices[i].RegisteredAt(); !ok || !at.Equal(e) {
+			t.Errorf("devices[%d].RegisteredAt() = %v, %v, expected %v", i, at, ok, e)
+		}
+	}
+	if _, ok := devices[2].RegisteredAt(); ok {
+		t.Errorf("sms device RegisteredAt() ok = true, expected false")
+	}
+
+	n, err := client.AppDeviceCount(12345)
+	if err != nil || n != 2 {
+		t.Errorf("AppDeviceCount = %d, %v, expected 2, nil", n, err)
+	}
+}
+
+func TestCheckOTPTokenFormat(t *testing.T) {
+	setup()
+	defer teardown()
+
+	httpmock.RegisterNoResponder(httpmock.NewStringResponder(200, `{"token": "is valid", "success": "true"}`))
+
+	for _, token := range []string{"12345", "123456789", "12345a", "123/456", "123 456", "../../app/details"} {
+		valid, err := client.CheckOTPToken(12345, token)
+		if valid || err == nil {
+			t.Errorf("CheckOTPToken(%q) = %v, %v expected false and an error", token, valid, err)
+		}
+	}
+	if n := httpmock.GetTotalCallCount(); n != 0 {
+		t.Errorf("CheckOTPToken made %d requests with malformed tokens, expected 0", n)
+	}
+
+	for _, token := range []string{"123456", "1234567", "12345678"} {
+		if valid, err := client.CheckOTPToken(12345, token); !valid || err != nil {
+			t.Errorf("CheckOTPToken(%q) = %v, %v expected true, nil", token, valid, err)
+		}
+	}
+}
+
+func TestNewClientFromEnv(t *testing.T) {
+	os.Setenv("AUTHY_API_SECRET", "envsecret")
+	os.Setenv("AUTHY_API_FORMAT", "xml")
+	defer os.Unsetenv("AUTHY_API_SECRET")
+	defer os.Unsetenv("AUTHY_API_FORMAT")
+
+	c, err := NewClientFromEnv(WithTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("NewClientFromEnv err = %v, expected nil", err)
+	}
+	if c.app.ApiSecret != "envsecret" || c.baseURL.String() != "https://api.authy.com/protected/xml/" {
+		t.Errorf("NewClientFromEnv got app %+v and base url %v", c.app, c.baseURL)
+	}
+	if c.Client.Timeout != time.Second {
+		t.Errorf("NewClientFromEnv Timeout = %v, expected %v", c.Client.Timeout, time.Second)
+	}
+
+	os.Unsetenv("AUTHY_API_SECRET")
+	if _, err := NewClientFromEnv(); err == nil {
+		t.Errorf("NewClientFromEnv without AUTHY_API_SECRET returned nil error")
+	}
+}
+
+func TestResendInstallLink(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var sent url.Values
+	httpmock.RegisterResponder("POST", "https://api.authy.com/protected/json/users/new",
+		func(req *http.Request) (*http.Response, error) {
+			b, _ := ioutil.ReadAll(req.Body)
+			sent, _ = url.ParseQuery(string(b))
+			return httpmock.NewStringResponse(200, `{"message": "User created successfully.", "user": {"id": 12345}, "success": true}`), nil
+		})
+
+	msg, err := client.ResendInstallLink(AuthyUser{Cellphone: "111111111", CountryCode: "61"})
+	if err != nil {
+		t.Fatalf("ResendInstallLink err = %v, expected nil", err)
+	}
+	if msg.User.ID != 12345 {
+		t.Errorf("ResendInstallLink User.ID = %v, expected 12345", msg.User.ID)
+	}
+	if sent.Get("send_install_link_via_sms") != "true" {
+		t.Errorf("ResendInstallLink did not ask for the install link, sent %v", sent.Encode())
+	}
+}
+
+func TestCanReceiveSMS(t *testing.T) {
+	setup()
+	defer teardown()
+
+	cases := []struct {
+		devices  string
+		expected bool
+	}{
+		{`[]`, true},
+		{`["sms"]`, true},
+		{`["sms", "voice"]`, true},
+		{`["android", "sms"]`, false},
+		{`[{"id": 1, "os_type": "ios"}]`, false},
+	}
+
+	for _, c := range cases {
+		httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/12345/status",
+			httpmock.NewStringResponder(200, `{"status": {"authy_id": 12345, "devices": `+c.devices+`}, "success": true}`))
+
+		can, err := client.CanReceiveSMS(12345)
+		if err != nil {
+			t.Fatalf("CanReceiveSMS err = %v, expected nil", err)
+		}
+		if can != c.expected {
+			t.Errorf("CanReceiveSMS with devices %v = %v, expected %v", c.devices, can, c.expected)
+		}
+	}
+}
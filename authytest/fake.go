@@ -0,0 +1,252 @@
+// Package authytest provides an in-memory fake of the authy client for
+// testing code that depends on it without making requests
+package authytest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	authy "github.com/michaellee93/authy-go"
+)
+
+// ErrRateLimited is the error authy returns when too many requests are made,
+// for use with FakeClient.SetError. It matches authy.ErrRateLimited
+var ErrRateLimited = &authy.APIError{
+	StatusCode: http.StatusTooManyRequests,
+	Message:    "Too many requests",
+}
+
+// Call is a method call recorded by FakeClient
+type Call struct {
+	Method      string // e.g. "SendOTP", context variants are recorded without the suffix
+	AuthyUserID int64
+}
+
+// FakeClient is an in-memory authy.AuthyClient. Users are created with ids
+// starting at 1 and tokens are only valid once set with SetToken. It is safe
+// for concurrent use
+type FakeClient struct {
+	mu     sync.Mutex
+	users  map[int64]authy.AuthyUser
+	tokens map[int64]string
+	errs   map[string]error
+	calls  []Call
+	nextID int64
+}
+
+var _ authy.AuthyClient = (*FakeClient)(nil)
+
+// NewFakeClient returns a FakeClient with no users
+func NewFakeClient() *FakeClient {
+	return &FakeClient{
+		users:  make(map[int64]authy.AuthyUser),
+		tokens: make(map[int64]string),
+		errs:   make(map[string]error),
+		nextID: 1,
+	}
+}
+
+// AddUser adds a user with the id given, as if they'd already been created
+func (f *FakeClient) AddUser(authyUserID int64, au authy.AuthyUser) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users[authyUserID] = au
+	if authyUserID >= f.nextID {
+		f.nextID = authyUserID + 1
+	}
+}
+
+// SetToken sets the token CheckOTPToken accepts for the user
+func (f *FakeClient) SetToken(authyUserID int64, token string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens[authyUserID] = token
+}
+
+// SetError makes calls to method, e.g. "SendOTP", return err until it is
+// set back to nil. An empty method applies err to every method
+func (f *FakeClient) SetError(method string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.errs, method)
+		return
+	}
+	f.errs[method] = err
+}
+
+// Calls returns the calls made to the client in order
+func (f *FakeClient) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// CallCount returns how many times method was called
+func (f *FakeClient) CallCount(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, c := range f.calls {
+		if c.Method == method {
+			n++
+		}
+	}
+	return n
+}
+
+// call records the call and returns the error it should fail with, if any.
+// f.mu must be held
+func (f *FakeClient) call(ctx context.Context, method string, authyUserID int64) error {
+	f.calls = append(f.calls, Call{Method: method, AuthyUserID: authyUserID})
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := f.errs[method]; err != nil {
+		return err
+	}
+	return f.errs[""]
+}
+
+// userNotFound is the error authy returns for an unknown user id
+func userNotFound() error {
+	return &authy.APIError{StatusCode: http.StatusNotFound, Message: "User not found.", Code: "60026"}
+}
+
+// GetAppInfo returns an app with every feature enabled
+func (f *FakeClient) GetAppInfo() (*authy.ResponseMessage, error) {
+	return f.GetAppInfoWithContext(context.Background())
+}
+
+// GetAppInfoWithContext is like GetAppInfo but uses the provided context
+func (f *FakeClient) GetAppInfoWithContext(ctx context.Context) (*authy.ResponseMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "GetAppInfo", 0); err != nil {
+		return nil, err
+	}
+
+	msg := &authy.ResponseMessage{Success: true, StatusCode: http.StatusOK}
+	msg.App.Name = "authytest"
+	msg.App.SmsEnabled = true
+	msg.App.PhoneCallsEnabled = true
+	msg.App.OnetouchEnabled = true
+	return msg, nil
+}
+
+// CreateUser adds the user and returns their new id
+func (f *FakeClient) CreateUser(au authy.AuthyUser) (int64, error) {
+	return f.CreateUserWithContext(context.Background(), au)
+}
+
+// CreateUserWithContext is like CreateUser but uses the provided context
+func (f *FakeClient) CreateUserWithContext(ctx context.Context, au authy.AuthyUser) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "CreateUser", 0); err != nil {
+		return 0, err
+	}
+	if au.Cellphone == "" || au.CountryCode == "" {
+		return 0, fmt.Errorf("AUTHY: insufficient data provided to create user")
+	}
+
+	// like authy, the same cellphone returns the existing user
+	for id, u := range f.users {
+		if u.Cellphone == au.Cellphone && u.CountryCode == au.CountryCode {
+			return id, nil
+		}
+	}
+
+	id := f.nextID
+	f.nextID++
+	f.users[id] = au
+	return id, nil
+}
+
+// RemoveUser removes the user and their token
+func (f *FakeClient) RemoveUser(authyUserID int64) error {
+	return f.RemoveUserWithContext(context.Background(), authyUserID)
+}
+
+// RemoveUserWithContext is like RemoveUser but uses the provided context
+func (f *FakeClient) RemoveUserWithContext(ctx context.Context, authyUserID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "RemoveUser", authyUserID); err != nil {
+		return err
+	}
+	if _, ok := f.users[authyUserID]; !ok {
+		return userNotFound()
+	}
+
+	delete(f.users, authyUserID)
+	delete(f.tokens, authyUserID)
+	return nil
+}
+
+// UserStatus returns the user as registered and confirmed
+func (f *FakeClient) UserStatus(authyUserID int64) (*authy.ResponseMessage, error) {
+	return f.UserStatusWithContext(context.Background(), authyUserID)
+}
+
+// UserStatusWithContext is like UserStatus but uses the provided context
+func (f *FakeClient) UserStatusWithContext(ctx context.Context, authyUserID int64) (*authy.ResponseMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "UserStatus", authyUserID); err != nil {
+		return nil, err
+	}
+	au, ok := f.users[authyUserID]
+	if !ok {
+		return nil, userNotFound()
+	}
+
+	msg := &authy.ResponseMessage{Success: true, StatusCode: http.StatusOK}
+	msg.Status.AuthyID = authyUserID
+	msg.Status.Registered = true
+	msg.Status.Confirmed = true
+	msg.Status.PhoneNumber = au.Cellphone
+	msg.Status.Email = au.Email
+	return msg, nil
+}
+
+// SendOTP records that a token was sent to the user
+func (f *FakeClient) SendOTP(authyUserID int64) (*authy.ResponseMessage, error) {
+	return f.SendOTPWithContext(context.Background(), authyUserID)
+}
+
+// SendOTPWithContext is like SendOTP but uses the provided context
+func (f *FakeClient) SendOTPWithContext(ctx context.Context, authyUserID int64) (*authy.ResponseMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "SendOTP", authyUserID); err != nil {
+		return nil, err
+	}
+	if _, ok := f.users[authyUserID]; !ok {
+		return nil, userNotFound()
+	}
+
+	return &authy.ResponseMessage{Success: true, StatusCode: http.StatusOK, Message: "SMS token was sent"}, nil
+}
+
+// CheckOTPToken reports whether token matches the one set with SetToken
+func (f *FakeClient) CheckOTPToken(authyUserID int64, token string) (bool, error) {
+	return f.CheckOTPTokenWithContext(context.Background(), authyUserID, token)
+}
+
+// CheckOTPTokenWithContext is like CheckOTPToken but uses the provided context
+func (f *FakeClient) CheckOTPTokenWithContext(ctx context.Context, authyUserID int64, token string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.call(ctx, "CheckOTPToken", authyUserID); err != nil {
+		return false, err
+	}
+	if _, ok := f.users[authyUserID]; !ok {
+		return false, userNotFound()
+	}
+
+	valid, ok := f.tokens[authyUserID]
+	return ok && token == valid, nil
+}
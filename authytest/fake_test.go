@@ -0,0 +1,81 @@
+package authytest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	authy "github.com/michaellee93/authy-go"
+)
+
+func TestFakeClient(t *testing.T) {
+	f := NewFakeClient()
+
+	id, err := f.CreateUser(authy.AuthyUser{Cellphone: "111111111", CountryCode: "61"})
+	if err != nil || id != 1 {
+		t.Fatalf("CreateUser = %d, %v, expected 1, nil", id, err)
+	}
+	f.SetToken(id, "123456")
+
+	if _, err := f.SendOTP(id); err != nil {
+		t.Errorf("SendOTP err = %v, expected nil", err)
+	}
+	if n := f.CallCount("SendOTP"); n != 1 {
+		t.Errorf("CallCount(SendOTP) = %d, expected 1", n)
+	}
+
+	if ok, err := f.CheckOTPToken(id, "123456"); !ok || err != nil {
+		t.Errorf("CheckOTPToken with valid token = %v, %v, expected true, nil", ok, err)
+	}
+	if ok, err := f.CheckOTPToken(id, "654321"); ok || err != nil {
+		t.Errorf("CheckOTPToken with invalid token = %v, %v, expected false, nil", ok, err)
+	}
+
+	msg, err := f.UserStatus(id)
+	if err != nil || !msg.Status.Registered {
+		t.Errorf("UserStatus = %+v, %v, expected registered user", msg, err)
+	}
+
+	if err := f.RemoveUser(id); err != nil {
+		t.Errorf("RemoveUser err = %v, expected nil", err)
+	}
+	var apiErr *authy.APIError
+	if _, err := f.SendOTP(id); !errors.As(err, &apiErr) || !errors.Is(err, authy.ErrUserNotFound) {
+		t.Errorf("SendOTP to removed user err = %v, expected 404 APIError", err)
+	}
+
+	expected := []string{"CreateUser", "SendOTP", "CheckOTPToken", "CheckOTPToken", "UserStatus", "RemoveUser", "SendOTP"}
+	calls := f.Calls()
+	if len(calls) != len(expected) {
+		t.Fatalf("Calls = %v, expected %v", calls, expected)
+	}
+	for i, c := range calls {
+		if c.Method != expected[i] {
+			t.Errorf("Calls[%d] = %v, expected %v", i, c.Method, expected[i])
+		}
+	}
+}
+
+func TestFakeClientSetError(t *testing.T) {
+	f := NewFakeClient()
+	f.AddUser(42, authy.AuthyUser{Cellphone: "111111111", CountryCode: "61"})
+
+	f.SetError("SendOTP", ErrRateLimited)
+	if _, err := f.SendOTP(42); !errors.Is(err, authy.ErrAPIResponse) {
+		t.Errorf("SendOTP err = %v, expected rate limited error", err)
+	}
+	if _, err := f.UserStatus(42); err != nil {
+		t.Errorf("UserStatus err = %v, expected nil", err)
+	}
+
+	f.SetError("SendOTP", nil)
+	if _, err := f.SendOTP(42); err != nil {
+		t.Errorf("SendOTP after clearing error err = %v, expected nil", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := f.SendOTPWithContext(ctx, 42); !errors.Is(err, context.Canceled) {
+		t.Errorf("SendOTPWithContext with cancelled context err = %v, expected context.Canceled", err)
+	}
+}
@@ -0,0 +1,158 @@
+package authy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// forEachID calls fn for each of the ids from at most concurrency goroutines,
+// stopping handing out ids once ctx is done
+func forEachID(ctx context.Context, ids []int64, concurrency int, fn func(id int64)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var wg sync.WaitGroup
+	jobs := make(chan int64)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				fn(id)
+			}
+		}()
+	}
+
+send:
+	for _, id := range ids {
+		// checked first as select picks randomly when a worker is also ready
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case jobs <- id:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// UserStatusBatch looks up the status of each of the ids, making at most
+// concurrency requests at once. Statuses and errors are returned keyed by
+// id; ids not looked up before ctx is done are given ctx's error
+func (c *Client) UserStatusBatch(ctx context.Context, ids []int64, concurrency int) (map[int64]*ResponseMessage, map[int64]error) {
+	statuses := make(map[int64]*ResponseMessage, len(ids))
+	errs := make(map[int64]error)
+
+	var mu sync.Mutex
+	forEachID(ctx, ids, concurrency, func(id int64) {
+		msg, err := c.UserStatusWithContext(ctx, id)
+
+		mu.Lock()
+		if err != nil {
+			errs[id] = err
+		} else {
+			statuses[id] = msg
+		}
+		mu.Unlock()
+	})
+
+	for _, id := range ids {
+		_, done := statuses[id]
+		if _, failed := errs[id]; !done && !failed {
+			errs[id] = ctx.Err()
+		}
+	}
+	return statuses, errs
+}
+
+// RemoveUsers removes each of the ids, making at most concurrency requests
+// at once. Every id is attempted rather than stopping at the first failure,
+// and the errors are returned keyed by id, so an empty map means all were
+// removed. Users authy doesn't know, e.g. because they were already
+// removed, count as removed. Ids not removed before ctx is done are given
+// ctx's error
+func (c *Client) RemoveUsers(ctx context.Context, ids []int64, concurrency int) map[int64]error {
+	errs := make(map[int64]error)
+	removed := make(map[int64]bool, len(ids))
+
+	var mu sync.Mutex
+	forEachID(ctx, ids, concurrency, func(id int64) {
+		err := c.RemoveUserWithContext(ctx, id)
+
+		mu.Lock()
+		if err != nil && !errors.Is(err, ErrUserNotFound) {
+			errs[id] = err
+		} else {
+			removed[id] = true
+		}
+		mu.Unlock()
+	})
+
+	for _, id := range ids {
+		if _, failed := errs[id]; !removed[id] && !failed {
+			errs[id] = ctx.Err()
+		}
+	}
+	return errs
+}
+
+// checkAnyConcurrency is the most verify requests CheckOTPTokenForAny makes
+// at once
+const checkAnyConcurrency = 4
+
+// CheckOTPTokenForAny checks the token against each of the ids and returns
+// the first id it is valid for, for when it isn't known which user a token
+// belongs to. If it is valid for none of them ErrNoMatchingUser is
+// returned, unless a check failed, in which case that error is returned,
+// e.g. one matching ErrUserNotFound for an id that doesn't exist. Every check counts towards the user's failed attempts, so only
+// pass the few ids the token could belong to - the more ids, the more
+// likely a token guessed for one user is valid for another
+func (c *Client) CheckOTPTokenForAny(ids []int64, token string) (int64, error) {
+	return c.CheckOTPTokenForAnyWithContext(context.Background(), ids, token)
+}
+
+// CheckOTPTokenForAnyWithContext is like CheckOTPTokenForAny but uses the
+// provided context. Checks still in flight are cancelled once a match is
+// found
+func (c *Client) CheckOTPTokenForAnyWithContext(ctx context.Context, ids []int64, token string) (int64, error) {
+	if !validToken(token) {
+		return 0, fmt.Errorf("AUTHY: token must be 6 to 8 digits")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	var match int64
+	var firstErr error
+	forEachID(ctx, ids, checkAnyConcurrency, func(id int64) {
+		result, err := c.VerifyOTPTokenWithContext(ctx, id, token)
+
+		mu.Lock()
+		switch {
+		case match != 0:
+		case err == nil && result.Valid:
+			match = id
+			cancel()
+		case err != nil && firstErr == nil && ctx.Err() == nil:
+			firstErr = err
+		}
+		mu.Unlock()
+	})
+
+	switch {
+	case match != 0:
+		return match, nil
+	case firstErr != nil:
+		return 0, firstErr
+	case ctx.Err() != nil:
+		return 0, ctx.Err()
+	}
+	return 0, ErrNoMatchingUser
+}
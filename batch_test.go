@@ -0,0 +1,165 @@
+package authy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestUserStatusBatch(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	httpmock.RegisterRegexpResponder("GET", regexp.MustCompile(`^https://api\.authy\.com/protected/json/users/(\d+)/status$`),
+		func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond * 5)
+
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+
+			id := httpmock.MustGetSubmatchAsInt(req, 1)
+			if id == 13 {
+				return httpmock.NewStringResponse(404, `{"message": "User not found.", "success": false}`), nil
+			}
+			return httpmock.NewStringResponse(200, fmt.Sprintf(`{"status": {"authy_id": %d}, "success": true}`, id)), nil
+		})
+
+	var ids []int64
+	for id := int64(1); id <= 20; id++ {
+		ids = append(ids, id)
+	}
+
+	statuses, errs := client.UserStatusBatch(context.Background(), ids, 3)
+	if len(statuses) != 19 || len(errs) != 1 {
+		t.Fatalf("UserStatusBatch returned %d statuses and %d errors, expected 19 and 1", len(statuses), len(errs))
+	}
+	if errs[13] == nil {
+		t.Errorf("UserStatusBatch expected an error for id 13")
+	}
+	for id, msg := range statuses {
+		if msg.Status.AuthyID != id {
+			t.Errorf("UserStatusBatch[%d] AuthyID = %d", id, msg.Status.AuthyID)
+		}
+	}
+	if maxInFlight > 3 {
+		t.Errorf("UserStatusBatch made %d concurrent requests, expected at most 3", maxInFlight)
+	}
+}
+
+func TestUserStatusBatchCancelled(t *testing.T) {
+	setup()
+	defer teardown()
+
+	httpmock.RegisterNoResponder(httpmock.NewStringResponder(200, `{"success": true}`))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ids := []int64{1, 2, 3, 4, 5}
+	statuses, errs := client.UserStatusBatch(ctx, ids, 2)
+	if len(statuses)+len(errs) != len(ids) {
+		t.Errorf("UserStatusBatch returned %d statuses and %d errors, expected %d in total", len(statuses), len(errs), len(ids))
+	}
+	if len(errs) != len(ids) {
+		t.Errorf("UserStatusBatch with a cancelled context returned %d errors, expected %d", len(errs), len(ids))
+	}
+	if n := httpmock.GetTotalCallCount(); n != 0 {
+		t.Errorf("UserStatusBatch with a cancelled context made %d requests, expected 0", n)
+	}
+}
+
+func TestCheckOTPTokenForAny(t *testing.T) {
+	setup()
+	defer teardown()
+
+	httpmock.RegisterRegexpResponder("GET", regexp.MustCompile(`^https://api\.authy\.com/protected/json/verify/123456/(\d+)$`),
+		func(req *http.Request) (*http.Response, error) {
+			switch httpmock.MustGetSubmatchAsInt(req, 1) {
+			case 7:
+				return httpmock.NewStringResponse(200, `{"message": "Token is valid.", "token": "is valid", "success": true}`), nil
+			case 99:
+				return httpmock.NewStringResponse(500, `{"message": "Internal error", "success": false}`), nil
+			case 404:
+				return httpmock.NewStringResponse(404, `{"message": "User not found.", "error_code": "60026", "success": false}`), nil
+			}
+			return httpmock.NewStringResponse(401, `{"message": "Token is invalid", "token": "is invalid", "success": false, "error_code": "60020"}`), nil
+		})
+
+	id, err := client.CheckOTPTokenForAny([]int64{1, 2, 3, 7, 8, 9}, "123456")
+	if err != nil || id != 7 {
+		t.Errorf("CheckOTPTokenForAny = %d, %v, expected 7", id, err)
+	}
+
+	// a wrong token can be told apart from a bad user id
+	_, err = client.CheckOTPTokenForAny([]int64{1, 2, 3}, "123456")
+	if !errors.Is(err, ErrNoMatchingUser) || errors.Is(err, ErrUserNotFound) {
+		t.Errorf("CheckOTPTokenForAny without a match err = %v, expected ErrNoMatchingUser", err)
+	}
+	_, err = client.CheckOTPTokenForAny([]int64{1, 404}, "123456")
+	if !errors.Is(err, ErrUserNotFound) || errors.Is(err, ErrNoMatchingUser) {
+		t.Errorf("CheckOTPTokenForAny with a missing user err = %v, expected to match ErrUserNotFound", err)
+	}
+
+	_, err = client.CheckOTPTokenForAny([]int64{1, 99}, "123456")
+	if !errors.Is(err, ErrAPIResponse) || errors.Is(err, ErrUserNotFound) {
+		t.Errorf("CheckOTPTokenForAny with a failed check err = %v, expected the *APIError", err)
+	}
+
+	if _, err := client.CheckOTPTokenForAny([]int64{1}, "12"); err == nil {
+		t.Errorf("CheckOTPTokenForAny with an invalid token returned nil error")
+	}
+}
+
+func TestRemoveUsers(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var mu sync.Mutex
+	removed := make(map[int]bool)
+	httpmock.RegisterRegexpResponder("POST", regexp.MustCompile(`^https://api\.authy\.com/protected/json/users/(\d+)/remove$`),
+		func(req *http.Request) (*http.Response, error) {
+			id := httpmock.MustGetSubmatchAsInt(req, 1)
+			switch id {
+			case 4:
+				return httpmock.NewStringResponse(200, `{"message": "User not found.", "error_code": "60026", "success": false}`), nil
+			case 5, 9:
+				return httpmock.NewStringResponse(500, `{"message": "Internal error", "success": false}`), nil
+			}
+			mu.Lock()
+			removed[int(id)] = true
+			mu.Unlock()
+			return httpmock.NewStringResponse(200, `{"message": "User was added to remove.", "success": true}`), nil
+		})
+
+	errs := client.RemoveUsers(context.Background(), []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 3)
+	if len(errs) != 2 || errs[5] == nil || errs[9] == nil {
+		t.Errorf("RemoveUsers errors = %v, expected errors for 5 and 9", errs)
+	}
+	if len(removed) != 7 {
+		t.Errorf("RemoveUsers removed %d users, expected 7", len(removed))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	errs = client.RemoveUsers(ctx, []int64{1, 2, 3}, 2)
+	if len(errs) != 3 || !errors.Is(errs[1], context.Canceled) {
+		t.Errorf("RemoveUsers with a cancelled context errors = %v, expected context.Canceled for each", errs)
+	}
+}
@@ -0,0 +1,68 @@
+package authy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+)
+
+// TestConcurrentUse is most useful run with -race
+func TestConcurrentUse(t *testing.T) {
+	var mu sync.Mutex
+	hooked := 0
+	cc, _ := NewClient(App{ApiSecret: "verysecret"},
+		WithRateLimit(10000, 100),
+		WithRetry(2, time.Millisecond),
+		WithAppInfoCache(time.Minute),
+		WithCreatedUserCache(time.Minute),
+		WithResponseHook(func(resp *http.Response, err error, elapsed time.Duration) {
+			mu.Lock()
+			hooked++
+			mu.Unlock()
+		}),
+	)
+	httpmock.ActivateNonDefault(cc.Client)
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/app/details",
+		httpmock.NewStringResponder(200, `{"app": {"name": "Test App"}, "success": true}`))
+	httpmock.RegisterResponder("POST", "https://api.authy.com/protected/json/users/new",
+		httpmock.NewStringResponder(200, `{"user": {"id": 12345}, "success": true}`))
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/sms/12345",
+		httpmock.NewStringResponder(200, `{"success": true}`))
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/12345/status",
+		httpmock.NewStringResponder(200, `{"status": {"registered": true}, "success": true}`))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, err := cc.GetAppInfo(); err != nil {
+					t.Errorf("GetAppInfo err = %v", err)
+				}
+				if _, err := cc.CreateUser(AuthyUser{Cellphone: "111111111", CountryCode: "61"}); err != nil {
+					t.Errorf("CreateUser err = %v", err)
+				}
+				if _, err := cc.SendOTP(12345); err != nil {
+					t.Errorf("SendOTP err = %v", err)
+				}
+				if _, err := cc.RefreshAppInfo(context.Background()); err != nil {
+					t.Errorf("RefreshAppInfo err = %v", err)
+				}
+				cc.UserStatus(12345)
+				cc.RateLimit()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if hooked == 0 {
+		t.Errorf("response hook was never called")
+	}
+}
@@ -0,0 +1,77 @@
+package authy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// debugWriter dumps requests and responses for WithDebug
+type debugWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// WithDebug writes the url, headers and body of every request the client
+// makes and the response to it to w, with the API key redacted. It is meant
+// for troubleshooting and is off by default
+func WithDebug(w io.Writer) Option {
+	return func(c *Client) {
+		if w == nil {
+			c.debug = nil
+			return
+		}
+		c.debug = &debugWriter{w: w}
+	}
+}
+
+// dump writes the request and its response or error as a single block, so
+// dumps from concurrent requests aren't interleaved
+func (d *debugWriter) dump(req *http.Request, resp *http.Response, body []byte, err error, elapsed time.Duration) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "> %s %s\n", req.Method, redactURL(req.URL))
+	writeHeaders(&b, "> ", req.Header)
+	if req.GetBody != nil {
+		if rc, err := req.GetBody(); err == nil {
+			reqBody, _ := ioutil.ReadAll(rc)
+			rc.Close()
+			if len(reqBody) > 0 {
+				fmt.Fprintf(&b, ">\n> %s\n", redactBody(reqBody))
+			}
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintf(&b, "< error after %v: %v\n\n", elapsed, err)
+	} else {
+		fmt.Fprintf(&b, "< %s (%v)\n", resp.Status, elapsed)
+		writeHeaders(&b, "< ", resp.Header)
+		fmt.Fprintf(&b, "<\n< %s\n\n", body)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.w.Write(b.Bytes())
+}
+
+func writeHeaders(b *bytes.Buffer, prefix string, h http.Header) {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, v := range h[name] {
+			if http.CanonicalHeaderKey(name) == "X-Authy-Api-Key" {
+				v = RedactKey(v)
+			}
+			fmt.Fprintf(b, "%s%s: %s\n", prefix, name, v)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package authy
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestWithDebug(t *testing.T) {
+	var out bytes.Buffer
+	dc, _ := NewClient(App{ApiSecret: "verysecret"}, WithDebug(&out))
+	httpmock.ActivateNonDefault(dc.Client)
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("POST", "https://api.authy.com/protected/json/users/new",
+		httpmock.NewStringResponder(200, `{"user": {"id": 12345}, "success": true}`))
+
+	if _, err := dc.CreateUser(AuthyUser{Cellphone: "111111111", CountryCode: "61"}); err != nil {
+		t.Fatalf("CreateUser err = %v, expected nil", err)
+	}
+
+	dump := out.String()
+	for _, expected := range []string{
+		"> POST https://api.authy.com/protected/json/users/new",
+		"> X-Authy-Api-Key: **********",
+		"user%5Bcellphone%5D=111111111",
+		"< 200",
+		`{"user": {"id": 12345}, "success": true}`,
+	} {
+		if !strings.Contains(dump, expected) {
+			t.Errorf("debug output doesn't contain %q:\n%s", expected, dump)
+		}
+	}
+	if strings.Contains(dump, "verysecret") {
+		t.Errorf("debug output contains the api key:\n%s", dump)
+	}
+}
+
+func TestWithDebugRedactsCodes(t *testing.T) {
+	var out bytes.Buffer
+	dc, _ := NewClient(App{ApiSecret: "verysecret"}, WithDebug(&out))
+	httpmock.ActivateNonDefault(dc.Client)
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("POST", "https://api.authy.com/protected/json/phones/verification/start",
+		httpmock.NewStringResponder(200, `{"success": true}`))
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/phones/verification/check",
+		httpmock.NewStringResponder(200, `{"success": true}`))
+
+	if _, err := dc.StartPhoneVerificationWithOptions("1", "111-111-1111", DeliverySMS, PhoneVerificationOptions{CustomCode: "4815162342"}); err != nil {
+		t.Fatalf("StartPhoneVerificationWithOptions err = %v, expected nil", err)
+	}
+	if _, err := dc.CheckPhoneVerification("1", "111-111-1111", "987123"); err != nil {
+		t.Fatalf("CheckPhoneVerification err = %v, expected nil", err)
+	}
+
+	dump := out.String()
+	for _, code := range []string{"4815162342", "987123"} {
+		if strings.Contains(dump, code) {
+			t.Errorf("debug output contains the code %s:\n%s", code, dump)
+		}
+	}
+	for _, expected := range []string{"custom_code=%2A%2A%2A%2A%2A%2A%2A%2A%2A%2A", "verification_code=%2A%2A%2A%2A%2A%2A"} {
+		if !strings.Contains(dump, expected) {
+			t.Errorf("debug output doesn't contain %q:\n%s", expected, dump)
+		}
+	}
+}
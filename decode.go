@@ -0,0 +1,87 @@
+package authy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// flexBool decodes booleans the authy API sometimes sends as strings
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		if s == "" {
+			*b = false
+			return nil
+		}
+		data = []byte(s)
+	}
+
+	v, err := strconv.ParseBool(string(data))
+	if err != nil {
+		return fmt.Errorf("AUTHY: invalid boolean %s", data)
+	}
+	*b = flexBool(v)
+	return nil
+}
+
+// UnmarshalJSON accepts booleans sent as either true or "true"
+func (m *ResponseMessage) UnmarshalJSON(data []byte) error {
+	type plain ResponseMessage
+	aux := struct {
+		*plain
+		Success     flexBool `json:"success"`
+		Ignored     flexBool `json:"ignored"`
+		IsCellphone flexBool `json:"is_cellphone"`
+	}{plain: (*plain)(m)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	m.Success = bool(aux.Success)
+	m.Ignored = bool(aux.Ignored)
+	m.IsCellphone = bool(aux.IsCellphone)
+	return nil
+}
+
+// UnmarshalJSON accepts booleans sent as either true or "true"
+func (s *Status) UnmarshalJSON(data []byte) error {
+	type plain Status
+	aux := struct {
+		*plain
+		Confirmed  flexBool `json:"confirmed"`
+		Registered flexBool `json:"registered"`
+	}{plain: (*plain)(s)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	s.Confirmed = bool(aux.Confirmed)
+	s.Registered = bool(aux.Registered)
+	return nil
+}
+
+// UnmarshalJSON accepts booleans sent as either true or "true"
+func (a *AppInfo) UnmarshalJSON(data []byte) error {
+	type plain AppInfo
+	aux := struct {
+		*plain
+		SmsEnabled        flexBool `json:"sms_enabled"`
+		PhoneCallsEnabled flexBool `json:"phone_calls_enabled"`
+		OnetouchEnabled   flexBool `json:"onetouch_enabled"`
+	}{plain: (*plain)(a)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	a.SmsEnabled = bool(aux.SmsEnabled)
+	a.PhoneCallsEnabled = bool(aux.PhoneCallsEnabled)
+	a.OnetouchEnabled = bool(aux.OnetouchEnabled)
+	return nil
+}
@@ -0,0 +1,54 @@
+package authy
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestResponseMessageMixedBooleans(t *testing.T) {
+	cases := []string{
+		`{
+			"success": true,
+			"ignored": true,
+			"is_cellphone": true,
+			"status": {"confirmed": true, "registered": true},
+			"app": {"sms_enabled": true, "phone_calls_enabled": true, "onetouch_enabled": true}
+		}`,
+		`{
+			"success": "true",
+			"ignored": "true",
+			"is_cellphone": "true",
+			"status": {"confirmed": "true", "registered": "true"},
+			"app": {"sms_enabled": "true", "phone_calls_enabled": "true", "onetouch_enabled": "true"}
+		}`,
+	}
+
+	for _, c := range cases {
+		var m ResponseMessage
+		if err := json.Unmarshal([]byte(c), &m); err != nil {
+			t.Fatalf("ResponseMessage.UnmarshalJSON err = %v, expected nil", err)
+		}
+		if !m.Success || !m.Ignored || !m.IsCellphone {
+			t.Errorf("ResponseMessage.UnmarshalJSON(%v) = %+v", c, m)
+		}
+		if !m.Status.Confirmed || !m.Status.Registered {
+			t.Errorf("ResponseMessage.UnmarshalJSON(%v) Status = %+v", c, m.Status)
+		}
+		if !m.App.SmsEnabled || !m.App.PhoneCallsEnabled || !m.App.OnetouchEnabled {
+			t.Errorf("ResponseMessage.UnmarshalJSON(%v) App = %+v", c, m.App)
+		}
+	}
+
+	var m ResponseMessage
+	body := `{"success": "false", "message": "Not found", "user": {"id": 12345}, "status": {"confirmed": false, "registered": null}}`
+	if err := json.Unmarshal([]byte(body), &m); err != nil {
+		t.Fatalf("ResponseMessage.UnmarshalJSON err = %v, expected nil", err)
+	}
+	if m.Success || m.Status.Confirmed || m.Status.Registered || m.Message != "Not found" || m.User.ID != 12345 {
+		t.Errorf("ResponseMessage.UnmarshalJSON(%v) = %+v", body, m)
+	}
+
+	if err := json.Unmarshal([]byte(`{"success": "maybe"}`), &m); err == nil {
+		t.Errorf("ResponseMessage.UnmarshalJSON with an invalid boolean returned nil error")
+	}
+}
@@ -0,0 +1,83 @@
+package authy
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Enroll creates the user, sending them the Authy app install link, then
+// polls their status every pollInterval until they've completed
+// registration, as reported by Status.RegistrationComplete, or timeout
+// passes. A user that already exists is enrolled with their existing
+// id, as authy returns it when the same phone number is registered again.
+// The id is returned whenever the user was created, even when waiting for
+// them to register fails
+func (c *Client) Enroll(ctx context.Context, au AuthyUser, pollInterval, timeout time.Duration) (int64, *UserRegistration, error) {
+	if pollInterval <= 0 {
+		pollInterval = time.Second
+	}
+
+	au.SendInstallLink = true
+	msg, err := c.CreateUserDetailedWithContext(ctx, au)
+	if err != nil {
+		return 0, nil, err
+	}
+	id := msg.User.ID
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var last *UserRegistration
+	err = poll(ctx, every(pollInterval), func() (bool, error) {
+		reg, err := c.UserRegistrationStatusWithContext(ctx, id)
+		if err != nil {
+			return false, err
+		}
+		last = reg
+		return reg.State == Confirmed, nil
+	})
+	if err != nil && ctx.Err() != nil {
+		return id, last, fmt.Errorf("AUTHY: user %d didn't complete registration: %w", id, err)
+	}
+	if err != nil {
+		return id, nil, err
+	}
+	return id, last, nil
+}
+
+// IsRegistered reports whether the user has completed registration, by both
+// registering the Authy app and confirming their phone, see
+// Status.RegistrationComplete
+func (c *Client) IsRegistered(ctx context.Context, authyUserID int64) (bool, error) {
+	msg, err := c.UserStatusWithContext(ctx, authyUserID)
+	if err != nil {
+		return false, err
+	}
+	return msg.Status.RegistrationComplete(), nil
+}
+
+// WaitForRegistration polls IsRegistered every interval until the user has
+// completed registration, or returns an error once timeout passes or ctx is
+// done. A timeout of 0 waits until ctx is done
+func (c *Client) WaitForRegistration(ctx context.Context, authyUserID int64, interval, timeout time.Duration) error {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	err := poll(ctx, every(interval), func() (bool, error) {
+		return c.IsRegistered(ctx, authyUserID)
+	})
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("AUTHY: user %d hasn't completed registration: %w", authyUserID, err)
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package authy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestEnroll(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var installLink string
+	httpmock.RegisterResponder("POST", "https://api.authy.com/protected/json/users/new",
+		func(req *http.Request) (*http.Response, error) {
+			req.ParseForm()
+			installLink = req.PostForm.Get("send_install_link_via_sms")
+			return httpmock.NewStringResponse(200, `{"user": {"id": 12345}, "success": true}`), nil
+		})
+
+	polls := 0
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/12345/status",
+		func(req *http.Request) (*http.Response, error) {
+			polls++
+			switch polls {
+			case 1:
+				return httpmock.NewStringResponse(200, `{"status": {"registered": false}, "success": true}`), nil
+			case 2:
+				// registered but not confirmed is still waited on
+				return httpmock.NewStringResponse(200, `{"status": {"registered": true, "confirmed": false}, "success": true}`), nil
+			}
+			return httpmock.NewStringResponse(200, `{"status": {"registered": true, "confirmed": true}, "success": true}`), nil
+		})
+
+	id, reg, err := client.Enroll(context.Background(), AuthyUser{Cellphone: "111111111", CountryCode: "61"}, time.Millisecond, time.Second)
+	if err != nil {
+		t.Fatalf("Enroll err = %v, expected nil", err)
+	}
+	if id != 12345 || reg.State != Confirmed {
+		t.Errorf("Enroll = %d, %+v, expected 12345 and confirmed", id, reg)
+	}
+	if installLink != "true" {
+		t.Errorf("Enroll send_install_link_via_sms = %q, expected true", installLink)
+	}
+	if polls != 3 {
+		t.Errorf("Enroll polled %d times, expected 3", polls)
+	}
+
+	// ctx is done while the second poll is in flight
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	polls = 0
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/12345/status",
+		func(req *http.Request) (*http.Response, error) {
+			polls++
+			if polls == 2 {
+				cancel()
+				return nil, context.Canceled
+			}
+			return httpmock.NewStringResponse(200, `{"status": {"registered": false}, "success": true}`), nil
+		})
+
+	id, reg, err = client.Enroll(ctx, AuthyUser{Cellphone: "111111111", CountryCode: "61"}, time.Millisecond, time.Second)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Enroll err = %v, expected context.Canceled", err)
+	}
+	if id != 12345 || reg == nil || reg.State != Unregistered {
+		t.Errorf("Enroll = %d, %+v, expected id and the last status seen", id, reg)
+	}
+}
+
+func TestWaitForRegistration(t *testing.T) {
+	setup()
+	defer teardown()
+
+	// registered but not confirmed isn't complete
+	polls := 0
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/12345/status",
+		func(req *http.Request) (*http.Response, error) {
+			polls++
+			if polls < 3 {
+				return httpmock.NewStringResponse(200, `{"status": {"registered": true, "confirmed": false}, "success": true}`), nil
+			}
+			return httpmock.NewStringResponse(200, `{"status": {"registered": true, "confirmed": true}, "success": true}`), nil
+		})
+
+	ok, err := client.IsRegistered(context.Background(), 12345)
+	if ok || err != nil {
+		t.Errorf("IsRegistered = %v, %v, expected false, nil for unconfirmed user", ok, err)
+	}
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/54321/status",
+		httpmock.NewStringResponder(200, `{"status": {"registered": false, "confirmed": true}, "success": true}`))
+	ok, err = client.IsRegistered(context.Background(), 54321)
+	if ok || err != nil {
+		t.Errorf("IsRegistered = %v, %v, expected false, nil for confirmed user without the app", ok, err)
+	}
+
+	if err := client.WaitForRegistration(context.Background(), 12345, time.Millisecond, time.Second); err != nil {
+		t.Errorf("WaitForRegistration err = %v, expected nil", err)
+	}
+	if polls != 3 {
+		t.Errorf("WaitForRegistration polled %d times, expected 3", polls)
+	}
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/12345/status",
+		httpmock.NewStringResponder(200, `{"status": {"registered": false, "confirmed": true}, "success": true}`))
+	err = client.WaitForRegistration(context.Background(), 12345, time.Millisecond, time.Millisecond*20)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitForRegistration err = %v, expected context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,135 @@
+package authy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Errors returned by the client can be matched against these with errors.Is
+// to tell what kind of failure occurred
+var (
+	// ErrNetwork is matched by errors making or reading a request
+	ErrNetwork = errors.New("AUTHY: network error")
+	// ErrAPIResponse is matched by an *APIError
+	ErrAPIResponse = errors.New("AUTHY: api error")
+	// ErrDecode is matched by errors decoding data from authy
+	ErrDecode = errors.New("AUTHY: decode error")
+	// ErrEmptyResponse is matched, along with ErrDecode, when a successful
+	// response has no body. A non-2xx response with no body is an *APIError
+	// with just the status code
+	ErrEmptyResponse = errors.New("AUTHY: empty response body")
+	// ErrResponseTooLarge is matched, along with ErrNetwork, when a response
+	// body is over the limit set by WithMaxResponseBytes
+	ErrResponseTooLarge = errors.New("AUTHY: response too large")
+	// ErrUserNotFound is matched by an *APIError for an authy user id that
+	// doesn't exist, e.g. one that was already removed
+	ErrUserNotFound = errors.New("AUTHY: user not found")
+	// ErrServiceUnavailable is matched by an *APIError for a 503 response,
+	// which authy returns during maintenance
+	ErrServiceUnavailable = errors.New("AUTHY: service unavailable")
+	// ErrRateLimited is matched by an *APIError for a 429 response. Its
+	// RetryAfter is how long authy asked the client to wait
+	ErrRateLimited = errors.New("AUTHY: rate limited")
+	// ErrNoMatchingUser is returned by CheckOTPTokenForAny when the token
+	// isn't valid for any of the users
+	ErrNoMatchingUser = errors.New("AUTHY: token is not valid for any of the users")
+)
+
+// APIError is returned when the Authy API responds with a status code
+// outside of the 2xx range
+type APIError struct {
+	StatusCode int    `json:"-" xml:"-"`
+	Message    string `json:"message" xml:"message"`
+	Code       string `json:"error_code" xml:"error_code"`
+
+	// Errors are the messages for each field authy rejected, e.g.
+	// "cellphone": "is invalid". It is only decoded from json responses
+	Errors map[string]string `json:"errors" xml:"-"`
+
+	// RetryAfter is how long authy asked the client to wait before trying
+	// again, e.g. during maintenance or when rate limited. Both the seconds
+	// and HTTP date forms of Retry-After are read. It is 0 if no
+	// Retry-After was sent
+	RetryAfter time.Duration `json:"-" xml:"-"`
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("AUTHY: request failed with status %d", e.StatusCode)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if fields := e.fieldErrors(); fields != "" {
+		msg += " (" + fields + ")"
+	}
+	return msg
+}
+
+// fieldErrors formats Errors in a stable order, leaving out the message
+// authy repeats in them
+func (e *APIError) fieldErrors() string {
+	var fields []string
+	for k, v := range e.Errors {
+		if k != "message" {
+			fields = append(fields, k+" "+v)
+		}
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ", ")
+}
+
+// Is makes errors.Is(err, ErrAPIResponse) true for an *APIError,
+// errors.Is(err, ErrServiceUnavailable) true when the status is 503,
+// errors.Is(err, ErrRateLimited) true when the status is 429 and
+// errors.Is(err, ErrUserNotFound) true when authy doesn't know the user
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrAPIResponse:
+		return true
+	case ErrServiceUnavailable:
+		return e.StatusCode == http.StatusServiceUnavailable
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrUserNotFound:
+		return e.Code == "60026" || e.StatusCode == http.StatusNotFound && strings.Contains(strings.ToLower(e.Message), "user not found")
+	}
+	return false
+}
+
+// networkError wraps an error from the http client
+type networkError struct {
+	err error
+}
+
+func (e *networkError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrNetwork, e.err)
+}
+
+func (e *networkError) Unwrap() error { return e.err }
+
+func (e *networkError) Is(target error) bool {
+	return target == ErrNetwork
+}
+
+// decodeError wraps an error unmarshaling data from authy, with context
+// describing what was being decoded
+type decodeError struct {
+	err     error
+	context string
+}
+
+func (e *decodeError) Error() string {
+	if e.context == "" {
+		return fmt.Sprintf("%v: %v", ErrDecode, e.err)
+	}
+	return fmt.Sprintf("%v: %s: %v", ErrDecode, e.context, e.err)
+}
+
+func (e *decodeError) Unwrap() error { return e.err }
+
+func (e *decodeError) Is(target error) bool {
+	return target == ErrDecode
+}
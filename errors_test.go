@@ -0,0 +1,233 @@
+package authy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestErrorKinds(t *testing.T) {
+	setup()
+	defer teardown()
+
+	httpmock.RegisterResponder("POST", "https://api.authy.com/protected/json/users/new",
+		httpmock.NewStringResponder(400, `{"message": "User already exists", "error_code": "60027", "success": false}`))
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/app/details",
+		httpmock.NewErrorResponder(context.DeadlineExceeded))
+
+	_, err := client.CreateUser(AuthyUser{Cellphone: "111111111", CountryCode: "61"})
+	if !errors.Is(err, ErrAPIResponse) {
+		t.Errorf("CreateUser err = %v, expected to match ErrAPIResponse", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "60027" || apiErr.StatusCode != 400 {
+		t.Errorf("CreateUser err = %+v, expected *APIError with code 60027", err)
+	}
+
+	_, err = client.GetAppInfo()
+	if !errors.Is(err, ErrNetwork) || errors.Is(err, ErrAPIResponse) {
+		t.Errorf("GetAppInfo err = %v, expected to match only ErrNetwork", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetAppInfo err = %v, expected to wrap context.DeadlineExceeded", err)
+	}
+
+	r := httptest.NewRequest("POST", "https://example.com/authy/callback", strings.NewReader(`{"bad json`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-Authy-Signature", "sig")
+	r.Header.Set("X-Authy-Signature-Nonce", "nonce")
+	if _, err := VerifyCallbackSignature(r, "verysecret"); !errors.Is(err, ErrDecode) {
+		t.Errorf("VerifyCallbackSignature err = %v, expected to match ErrDecode", err)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	setup()
+	defer teardown()
+
+	// authy can report a failure with a 200 and success false
+	httpmock.RegisterResponder("POST", "https://api.authy.com/protected/json/users/12345/remove",
+		httpmock.NewStringResponder(200, `{"message": "User not found.", "error_code": "60026", "success": false}`))
+
+	err := client.RemoveUser(12345)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("RemoveUser err = %v, expected *APIError", err)
+	}
+	if apiErr.Code != "60026" || apiErr.Message != "User not found." {
+		t.Errorf("RemoveUser err = %+v, expected code 60026", apiErr)
+	}
+
+	msg := new(ResponseMessage)
+	client.Post("users/12345/remove", nil, msg)
+	if msg.ErrorCode != "60026" {
+		t.Errorf("ResponseMessage ErrorCode = %v, expected 60026", msg.ErrorCode)
+	}
+}
+
+func TestFieldErrors(t *testing.T) {
+	setup()
+	defer teardown()
+
+	httpmock.RegisterResponder("POST", "https://api.authy.com/protected/json/users/new",
+		httpmock.NewStringResponder(400, `{"message": "User was not valid", "success": false,
+			"errors": {"message": "User was not valid", "cellphone": "is invalid", "email": "is invalid"},
+			"cellphone": "is invalid", "error_code": "60027"}`))
+
+	_, err := client.CreateUser(AuthyUser{Cellphone: "111111111", CountryCode: "61"})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("CreateUser err = %v, expected *APIError", err)
+	}
+	if apiErr.Errors["cellphone"] != "is invalid" {
+		t.Errorf("APIError.Errors = %v, expected cellphone error", apiErr.Errors)
+	}
+	expected := "AUTHY: request failed with status 400: User was not valid (cellphone is invalid, email is invalid)"
+	if err.Error() != expected {
+		t.Errorf("APIError.Error() = %q, expected %q", err.Error(), expected)
+	}
+
+	msg := new(ResponseMessage)
+	client.Post("users/new", nil, msg)
+	if msg.Errors["email"] != "is invalid" {
+		t.Errorf("ResponseMessage.Errors = %v, expected email error", msg.Errors)
+	}
+}
+
+func TestUserNotFound(t *testing.T) {
+	setup()
+	defer teardown()
+
+	httpmock.RegisterResponder("POST", "https://api.authy.com/protected/json/users/12345/remove",
+		httpmock.NewStringResponder(200, `{"message": "User not found.", "error_code": "60026", "success": false}`))
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/12345/status",
+		httpmock.NewStringResponder(404, `{"message": "User not found.", "success": false}`))
+
+	if err := client.RemoveUser(12345); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("RemoveUser err = %v, expected to match ErrUserNotFound", err)
+	}
+	if _, err := client.UserStatus(12345); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("UserStatus err = %v, expected to match ErrUserNotFound", err)
+	}
+	if errors.Is(&APIError{StatusCode: 404, Message: "Not found"}, ErrUserNotFound) {
+		t.Errorf("404 without user not found matched ErrUserNotFound")
+	}
+}
+
+func TestServiceUnavailable(t *testing.T) {
+	setup()
+	defer teardown()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/sms/12345",
+		func(req *http.Request) (*http.Response, error) {
+			resp := httpmock.NewStringResponse(503, `<html>down for maintenance</html>`)
+			resp.Header.Set("Retry-After", "120")
+			return resp, nil
+		})
+
+	_, err := client.SendOTP(12345)
+	if !errors.Is(err, ErrServiceUnavailable) || !errors.Is(err, ErrAPIResponse) {
+		t.Errorf("SendOTP err = %v, expected to match ErrServiceUnavailable", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.RetryAfter != time.Minute*2 {
+		t.Errorf("SendOTP err = %+v, expected RetryAfter of 2m", err)
+	}
+
+	if errors.Is(&APIError{StatusCode: 500}, ErrServiceUnavailable) {
+		t.Errorf("500 APIError matched ErrServiceUnavailable")
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	setup()
+	defer teardown()
+
+	retryAt := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/sms/12345",
+		func(req *http.Request) (*http.Response, error) {
+			resp := httpmock.NewStringResponse(429, `{"message": "Too many requests", "success": false}`)
+			resp.Header.Set("Retry-After", retryAt)
+			return resp, nil
+		})
+
+	_, err := client.SendOTP(12345)
+	if !errors.Is(err, ErrRateLimited) || errors.Is(err, ErrServiceUnavailable) {
+		t.Errorf("SendOTP err = %v, expected to match ErrRateLimited", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.RetryAfter <= 58*time.Second || apiErr.RetryAfter > time.Minute {
+		t.Errorf("SendOTP err = %+v, expected RetryAfter of about 1m", err)
+	}
+}
+
+func TestEmptyResponse(t *testing.T) {
+	setup()
+	defer teardown()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/12345/status",
+		httpmock.NewStringResponder(200, " \n"))
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/sms/12345",
+		httpmock.NewStringResponder(403, ``))
+
+	_, err := client.UserStatus(12345)
+	if !errors.Is(err, ErrEmptyResponse) || !errors.Is(err, ErrDecode) {
+		t.Errorf("UserStatus err = %v, expected to match ErrEmptyResponse and ErrDecode", err)
+	}
+
+	_, err = client.SendOTP(12345)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 403 || errors.Is(err, ErrEmptyResponse) {
+		t.Errorf("SendOTP err = %v, expected *APIError with status 403", err)
+	}
+}
+
+func TestNilResource(t *testing.T) {
+	setup()
+	defer teardown()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/app/details",
+		httpmock.NewStringResponder(200, `<html><body>OK</body></html>`))
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/12345/status",
+		httpmock.NewStringResponder(404, `{"message": "User not found.", "error_code": "60026", "success": false}`))
+
+	if err := client.Get("app/details", nil); err != nil {
+		t.Errorf("Get err = %v, expected nil when the body isn't wanted", err)
+	}
+	err := client.Get("users/12345/status", nil)
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Get err = %v, expected to match ErrUserNotFound", err)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	setup()
+	defer teardown()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/app/details",
+		httpmock.NewStringResponder(200, `<html><body>Service temporarily unavailable</body></html>`))
+
+	_, err := client.GetAppInfo()
+	if !errors.Is(err, ErrDecode) {
+		t.Fatalf("GetAppInfo err = %v, expected to match ErrDecode", err)
+	}
+	for _, s := range []string{"/protected/json/app/details", "<html><body>Service"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("GetAppInfo err = %v, expected it to contain %q", err, s)
+		}
+	}
+
+	// a failed status is reported rather than the body failing to decode
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/app/details",
+		httpmock.NewStringResponder(502, `<html>Bad Gateway</html>`))
+	_, err = client.GetAppInfo()
+	if !errors.Is(err, ErrAPIResponse) || errors.Is(err, ErrDecode) {
+		t.Errorf("GetAppInfo err = %v, expected to match only ErrAPIResponse", err)
+	}
+}
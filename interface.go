@@ -0,0 +1,25 @@
+package authy
+
+import "context"
+
+// AuthyClient is the set of methods most applications use, so code
+// depending on the client can be tested with a mock or fake. *Client
+// implements it
+type AuthyClient interface {
+	GetAppInfo() (*ResponseMessage, error)
+	GetAppInfoWithContext(ctx context.Context) (*ResponseMessage, error)
+
+	CreateUser(au AuthyUser) (int64, error)
+	CreateUserWithContext(ctx context.Context, au AuthyUser) (int64, error)
+	RemoveUser(authyUserID int64) error
+	RemoveUserWithContext(ctx context.Context, authyUserID int64) error
+	UserStatus(authyUserID int64) (*ResponseMessage, error)
+	UserStatusWithContext(ctx context.Context, authyUserID int64) (*ResponseMessage, error)
+
+	SendOTP(authyUserID int64) (*ResponseMessage, error)
+	SendOTPWithContext(ctx context.Context, authyUserID int64) (*ResponseMessage, error)
+	CheckOTPToken(authyUserID int64, token string) (bool, error)
+	CheckOTPTokenWithContext(ctx context.Context, authyUserID int64, token string) (bool, error)
+}
+
+var _ AuthyClient = (*Client)(nil)
@@ -0,0 +1,22 @@
+package authy
+
+// Logger is used by the client to report problems it recovers from, such as
+// malformed responses and retried requests. *log.Logger satisfies Logger
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// nopLogger discards everything logged to it and is the default Logger
+type nopLogger struct{}
+
+func (nopLogger) Printf(format string, v ...interface{}) {}
+
+// WithLogger sets the logger the client reports to. Nothing is logged
+// unless a logger is provided
+func WithLogger(l Logger) Option {
+	return func(c *Client) {
+		if l != nil {
+			c.logger = l
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package authy
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	lc, _ := NewClient(App{ApiSecret: "verysecret"}, WithLogger(log.New(&buf, "", 0)))
+	httpmock.ActivateNonDefault(lc.Client)
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/app/details",
+		httpmock.NewStringResponder(200, `<html>not json</html>`))
+
+	lc.GetAppInfo()
+	if !strings.Contains(buf.String(), "error decoding json response from /protected/json/app/details") {
+		t.Errorf("WithLogger logged %q, expected a decoding error", buf.String())
+	}
+
+	// the default logger discards everything
+	dc, _ := NewClient(App{ApiSecret: "verysecret"})
+	if _, ok := dc.logger.(nopLogger); !ok {
+		t.Errorf("NewClient logger is not a nopLogger by default")
+	}
+}
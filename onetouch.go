@@ -0,0 +1,447 @@
+package authy
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-querystring/query"
+)
+
+// oneTouchPath is the root of the OneTouch API, which lives outside of the
+// protected API and only speaks json. It is relative to the protected API's
+// json/ or xml/ path so a base url with extra path segments keeps them
+const oneTouchPath = "../../onetouch/json/"
+
+// ApprovalRequestOptions configures a OneTouch approval request. The
+// OneTouch API has no way to cancel or expire a request early, so set
+// SecondsToExpire to the length of the session it belongs to and ignore
+// responses to requests the session no longer needs
+type ApprovalRequestOptions struct {
+	Message         string          `url:"message"`
+	Details         ApprovalDetails `url:"details,omitempty"`        // shown to the user in the app, sorted by key
+	HiddenDetails   ApprovalDetails `url:"hidden_details,omitempty"` // kept with the request but not shown
+	SecondsToExpire int             `url:"seconds_to_expire,omitempty"`
+	Logos           ApprovalLogos   `url:"logos,omitempty"` // branding shown on the approval screen
+
+	// OrderedDetails are shown to the user in the app in the order given,
+	// use instead of Details when the order matters
+	OrderedDetails OrderedApprovalDetails `url:"-"`
+}
+
+// encodeForm encodes the options with OrderedDetails after the other
+// parameters, in their original order
+func (o ApprovalRequestOptions) encodeForm() (string, error) {
+	v, err := query.Values(o)
+	if err != nil {
+		return "", err
+	}
+	form := v.Encode()
+	for _, d := range o.OrderedDetails {
+		if form != "" {
+			form += "&"
+		}
+		form += url.QueryEscape("details["+d.Key+"]") + "=" + url.QueryEscape(d.Value)
+	}
+	return form, nil
+}
+
+// ApprovalLogo is an image shown on the approval screen at a resolution
+type ApprovalLogo struct {
+	Res string // one of default, low, med or high
+	URL string
+}
+
+// ApprovalLogos are the logos of an approval request. A default logo is
+// used when the device's resolution isn't given
+type ApprovalLogos []ApprovalLogo
+
+// EncodeValues encodes the logos as the logos[][res] and logos[][url]
+// parameters the OneTouch API expects
+func (l ApprovalLogos) EncodeValues(key string, v *url.Values) error {
+	for _, logo := range l {
+		v.Add(key+"[][res]", logo.Res)
+		v.Add(key+"[][url]", logo.URL)
+	}
+	return nil
+}
+
+// validate checks each logo has a resolution authy recognizes and a url
+func (l ApprovalLogos) validate() error {
+	for _, logo := range l {
+		switch logo.Res {
+		case "default", "low", "med", "high":
+		default:
+			return fmt.Errorf("AUTHY: logo resolution must be default, low, med or high, not %q", logo.Res)
+		}
+		if u, err := url.Parse(logo.URL); err != nil || !u.IsAbs() {
+			return fmt.Errorf("AUTHY: logo url %q must be absolute", logo.URL)
+		}
+	}
+	return nil
+}
+
+// ApprovalDetails are the key value pairs attached to an approval request
+type ApprovalDetails map[string]string
+
+// EncodeValues encodes the details as the key[name]=value parameters the
+// OneTouch API expects
+func (d ApprovalDetails) EncodeValues(key string, v *url.Values) error {
+	return bracketParams(d).EncodeValues(key, v)
+}
+
+// ApprovalDetail is a key value pair attached to an approval request
+type ApprovalDetail struct {
+	Key   string
+	Value string
+}
+
+// OrderedApprovalDetails are the key value pairs attached to an approval
+// request, sent in the order of the slice rather than sorted by key
+type OrderedApprovalDetails []ApprovalDetail
+
+// ApprovalStatus is the state of a OneTouch approval request
+type ApprovalStatus string
+
+// Statuses an approval request can be in
+const (
+	ApprovalPending  ApprovalStatus = "pending"
+	ApprovalApproved ApprovalStatus = "approved"
+	ApprovalDenied   ApprovalStatus = "denied"
+	ApprovalExpired  ApprovalStatus = "expired"
+)
+
+// ApprovalRequest is a OneTouch approval request as returned by the API
+type ApprovalRequest struct {
+	UUID            string         `json:"uuid"`
+	Status          ApprovalStatus `json:"status"`
+	AuthyID         int64          `json:"_authy_id"`
+	Notified        bool           `json:"notified"`
+	SecondsToExpire int            `json:"seconds_to_expire"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+	ProcessedAt     *time.Time     `json:"processed_at"` // nil until the user responds
+}
+
+// approvalRequestResponse is the wrapper for approval request data
+type approvalRequestResponse struct {
+	ApprovalRequest ApprovalRequest `json:"approval_request"`
+	Message         string          `json:"message"`
+	Success         bool            `json:"success"`
+}
+
+// CreateApprovalRequest sends a OneTouch push notification to the user asking
+// them to approve the message and returns the uuid of the approval request
+// https://www.twilio.com/docs/authy/api/push-authentications
+func (c *Client) CreateApprovalRequest(authyUserID int64, message string, details map[string]string) (string, error) {
+	return c.CreateApprovalRequestWithOptionsWithContext(context.Background(), authyUserID, ApprovalRequestOptions{
+		Message: message,
+		Details: details,
+	})
+}
+
+// CreateApprovalRequestWithContext is like CreateApprovalRequest but uses the
+// provided context
+func (c *Client) CreateApprovalRequestWithContext(ctx context.Context, authyUserID int64, message string, details map[string]string) (string, error) {
+	return c.CreateApprovalRequestWithOptionsWithContext(ctx, authyUserID, ApprovalRequestOptions{
+		Message: message,
+		Details: details,
+	})
+}
+
+// CreateApprovalRequestWithOptions is like CreateApprovalRequest but allows
+// hidden details and the expiry of the request to be set
+func (c *Client) CreateApprovalRequestWithOptions(authyUserID int64, opts ApprovalRequestOptions) (string, error) {
+	return c.CreateApprovalRequestWithOptionsWithContext(context.Background(), authyUserID, opts)
+}
+
+// CreateApprovalRequestWithOptionsWithContext is like
+// CreateApprovalRequestWithOptions but uses the provided context
+func (c *Client) CreateApprovalRequestWithOptionsWithContext(ctx context.Context, authyUserID int64, opts ApprovalRequestOptions) (string, error) {
+	if authyUserID == 0 || opts.Message == "" {
+		return "", fmt.Errorf("AUTHY: authyUserID or message not provided")
+	}
+	if err := opts.Logos.validate(); err != nil {
+		return "", err
+	}
+	if len(opts.Details) > 0 && len(opts.OrderedDetails) > 0 {
+		return "", fmt.Errorf("AUTHY: only one of Details and OrderedDetails can be set")
+	}
+
+	path := fmt.Sprintf("%susers/%d/approval_requests", oneTouchPath, authyUserID)
+	resource := new(approvalRequestResponse)
+	err := c.PostWithContext(ctx, path, opts, resource)
+	if err != nil {
+		return "", err
+	}
+
+	if !resource.Success {
+		return "", fmt.Errorf("AUTHY: approval request not successful %v", resource.Message)
+	}
+
+	return resource.ApprovalRequest.UUID, nil
+}
+
+// GetApprovalRequestStatus fetches the approval request with the given uuid
+// so its status can be polled after CreateApprovalRequest
+func (c *Client) GetApprovalRequestStatus(uuid string) (*ApprovalRequest, error) {
+	return c.GetApprovalRequestStatusWithContext(context.Background(), uuid)
+}
+
+// GetApprovalRequestStatusWithContext is like GetApprovalRequestStatus but
+// uses the provided context
+func (c *Client) GetApprovalRequestStatusWithContext(ctx context.Context, uuid string) (*ApprovalRequest, error) {
+	if uuid == "" {
+		return nil, fmt.Errorf("AUTHY: approval request uuid not provided")
+	}
+
+	path := fmt.Sprintf("%sapproval_requests/%s", oneTouchPath, url.PathEscape(uuid))
+	resource := new(approvalRequestResponse)
+	err := c.GetWithContext(ctx, path, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	if !resource.Success {
+		return nil, fmt.Errorf("AUTHY: approval request status not successful %v", resource.Message)
+	}
+
+	return &resource.ApprovalRequest, nil
+}
+
+// PollOptions configures how WaitForApproval polls. Zero values use the
+// defaults
+type PollOptions struct {
+	Interval    time.Duration // the first wait, 1 second by default
+	MaxInterval time.Duration // the longest wait, 10 seconds by default
+	Multiplier  float64       // how much the wait grows each poll, 1.5 by default
+}
+
+// WaitForApproval polls the status of the approval request, backing off with
+// jitter between polls, until the user approves or denies it or it expires,
+// and returns the request. If ctx is done first the last status seen is
+// returned with ctx's error
+func (c *Client) WaitForApproval(ctx context.Context, uuid string, opts PollOptions) (*ApprovalRequest, error) {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.MaxInterval <= 0 {
+		opts.MaxInterval = time.Second * 10
+	}
+	if opts.Multiplier < 1 {
+		opts.Multiplier = 1.5
+	}
+
+	interval := opts.Interval
+	wait := func() time.Duration {
+		d := jitter(interval)
+		interval = time.Duration(float64(interval) * opts.Multiplier)
+		if interval > opts.MaxInterval {
+			interval = opts.MaxInterval
+		}
+		return d
+	}
+
+	var last *ApprovalRequest
+	err := poll(ctx, wait, func() (bool, error) {
+		req, err := c.GetApprovalRequestStatusWithContext(ctx, uuid)
+		if err != nil {
+			return false, err
+		}
+		last = req
+		return req.Status != ApprovalPending, nil
+	})
+	if err != nil && ctx.Err() != nil && last != nil {
+		return last, fmt.Errorf("AUTHY: approval request %s still pending: %w", uuid, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return last, nil
+}
+
+// healthCheckUUID is looked up by OneTouchHealthy, no request has this uuid
+const healthCheckUUID = "00000000-0000-0000-0000-000000000000"
+
+// OneTouchHealthy reports whether OneTouch is enabled for the app and its API
+// is responding, so callers can fall back to OTPs when push isn't working.
+// OneTouch has no health endpoint and creating a request would notify a
+// user, so a request that doesn't exist is looked up instead. The error
+// explains why OneTouch is unhealthy when it was reached but failed
+func (c *Client) OneTouchHealthy(ctx context.Context) (bool, error) {
+	info, err := c.GetAppInfoWithContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	if !info.App.OnetouchEnabled {
+		return false, fmt.Errorf("AUTHY: onetouch is not enabled for the app")
+	}
+
+	path := fmt.Sprintf("%sapproval_requests/%s", oneTouchPath, healthCheckUUID)
+	err = c.GetWithContext(ctx, path, new(approvalRequestResponse))
+	// only authy's own not found error means the API is responding, a 404
+	// without one could be from a proxy or load balancer in front of it
+	var apiErr *APIError
+	if err == nil || errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound &&
+		(apiErr.Code != "" || apiErr.Message != "") {
+		return true, nil
+	}
+	return false, err
+}
+
+// VerifyCallbackSignature checks the X-Authy-Signature header of a OneTouch
+// callback made by Authy to your server was signed with the API key provided.
+// The request body is restored so it can still be read by the caller
+// https://www.twilio.com/docs/authy/api/webhooks#verifying-the-signature
+func VerifyCallbackSignature(r *http.Request, apiKey string) (bool, error) {
+	signature := r.Header.Get("X-Authy-Signature")
+	nonce := r.Header.Get("X-Authy-Signature-Nonce")
+	if signature == "" || nonce == "" {
+		return false, fmt.Errorf("AUTHY: callback signature or nonce header missing")
+	}
+
+	params, err := callbackParams(r)
+	if err != nil {
+		return false, err
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	callbackURL := fmt.Sprintf("%s://%s%s", scheme, r.Host, r.URL.RequestURI())
+
+	expected := callbackSignature(apiKey, nonce, r.Method, callbackURL, params)
+	return hmac.Equal([]byte(expected), []byte(signature)), nil
+}
+
+// ApprovalCallback is the body of the callback authy makes when a user
+// responds to an approval request
+type ApprovalCallback struct {
+	AuthyID        int64          `json:"authy_id"`
+	DeviceUUID     string         `json:"device_uuid"`
+	CallbackAction string         `json:"callback_action"`
+	UUID           string         `json:"uuid"`
+	Status         ApprovalStatus `json:"status"`
+
+	// Signature is the signature the user's device made of its response.
+	// Authy doesn't publish device public keys so it can't be checked here,
+	// use VerifyCallbackSignature to authenticate the callback itself
+	Signature string `json:"signature"`
+}
+
+// ParseApprovalCallback decodes the json body of a OneTouch callback. The
+// request body is restored so it can still be read by the caller, e.g. by
+// VerifyCallbackSignature
+func ParseApprovalCallback(r *http.Request) (*ApprovalCallback, error) {
+	if r.Body == nil {
+		return nil, fmt.Errorf("AUTHY: callback has no body")
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	cb := new(ApprovalCallback)
+	if err := json.Unmarshal(body, cb); err != nil {
+		return nil, &decodeError{err: err, context: "approval callback"}
+	}
+	return cb, nil
+}
+
+// callbackSignature is the base64 encoded HMAC-SHA256 of the nonce, method,
+// url and sorted params of a callback, joined by pipes
+func callbackSignature(apiKey, nonce, method, callbackURL string, params []string) string {
+	sort.Strings(params)
+	data := strings.Join([]string{nonce, method, callbackURL, strings.Join(params, "&")}, "|")
+
+	mac := hmac.New(sha256.New, []byte(apiKey))
+	mac.Write([]byte(data))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// callbackParams reads the body params of a callback as encoded key=value
+// pairs. JSON bodies are flattened into the nested key[sub]=value form. Query
+// params aren't included as they're already signed as part of the url
+func callbackParams(r *http.Request) ([]string, error) {
+	var params []string
+	if r.Body == nil {
+		return params, nil
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return params, nil
+	}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var v interface{}
+		d := json.NewDecoder(bytes.NewReader(body))
+		d.UseNumber()
+		if err := d.Decode(&v); err != nil {
+			return nil, &decodeError{err: err}
+		}
+		return flattenParams("", v, params), nil
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, &decodeError{err: err}
+	}
+	for k, vs := range form {
+		for _, v := range vs {
+			params = append(params, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+	}
+	return params, nil
+}
+
+// flattenParams appends the values in v to params using the nested query
+// string form, so {"a": {"b": 1}} becomes a[b]=1
+func flattenParams(key string, v interface{}, params []string) []string {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, sub := range val {
+			if key != "" {
+				k = key + "[" + k + "]"
+			}
+			params = flattenParams(k, sub, params)
+		}
+	case []interface{}:
+		for _, sub := range val {
+			params = flattenParams(key+"[]", sub, params)
+		}
+	case string:
+		params = append(params, url.QueryEscape(key)+"="+url.QueryEscape(val))
+	case json.Number:
+		params = append(params, url.QueryEscape(key)+"="+val.String())
+	case bool:
+		params = append(params, url.QueryEscape(key)+"="+strconv.FormatBool(val))
+	case nil:
+		params = append(params, url.QueryEscape(key)+"=")
+	}
+	return params
+}
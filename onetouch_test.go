@@ -0,0 +1,360 @@
+package authy
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestCreateApprovalRequest(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var sent url.Values
+	httpmock.RegisterResponder("POST", "https://api.authy.com/onetouch/json/users/12345/approval_requests",
+		func(req *http.Request) (*http.Response, error) {
+			b, _ := ioutil.ReadAll(req.Body)
+			sent, _ = url.ParseQuery(string(b))
+			return httpmock.NewStringResponse(200, `
+				{
+					"approval_request": {"uuid": "550e8400-e29b-41d4-a716-446655440000"},
+					"success": true
+				}`), nil
+		})
+
+	uuid, err := client.CreateApprovalRequestWithOptions(12345, ApprovalRequestOptions{
+		Message:         "Login requested",
+		Details:         map[string]string{"Username": "bob", "Location": "Sydney"},
+		HiddenDetails:   map[string]string{"ip": "10.0.0.1"},
+		SecondsToExpire: 120,
+	})
+	if err != nil {
+		t.Fatalf("CreateApprovalRequestWithOptions err = %v, expected nil", err)
+	}
+	if uuid != "550e8400-e29b-41d4-a716-446655440000" {
+		t.Errorf("CreateApprovalRequestWithOptions uuid = %v", uuid)
+	}
+
+	expected := url.Values{
+		"message":            {"Login requested"},
+		"details[Username]":  {"bob"},
+		"details[Location]":  {"Sydney"},
+		"hidden_details[ip]": {"10.0.0.1"},
+		"seconds_to_expire":  {"120"},
+	}
+	if sent.Encode() != expected.Encode() {
+		t.Errorf("CreateApprovalRequestWithOptions Body = %v, expected %v", sent.Encode(), expected.Encode())
+	}
+
+	if _, err := client.CreateApprovalRequest(12345, "", nil); err == nil {
+		t.Errorf("CreateApprovalRequest without a message returned nil error")
+	}
+
+	_, err = client.CreateApprovalRequestWithOptions(12345, ApprovalRequestOptions{
+		Message: "Login requested",
+		Logos: ApprovalLogos{
+			{Res: "default", URL: "https://example.com/logo.png"},
+			{Res: "high", URL: "https://example.com/logo@3x.png"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateApprovalRequestWithOptions with logos err = %v, expected nil", err)
+	}
+	if res := sent["logos[][res]"]; len(res) != 2 || res[0] != "default" || res[1] != "high" {
+		t.Errorf("CreateApprovalRequestWithOptions logos[][res] = %v, expected [default high]", res)
+	}
+	if urls := sent["logos[][url]"]; len(urls) != 2 || urls[1] != "https://example.com/logo@3x.png" {
+		t.Errorf("CreateApprovalRequestWithOptions logos[][url] = %v", urls)
+	}
+
+	for _, logo := range []ApprovalLogo{{Res: "huge", URL: "https://example.com/logo.png"}, {Res: "low", URL: "logo.png"}} {
+		_, err := client.CreateApprovalRequestWithOptions(12345, ApprovalRequestOptions{Message: "Login requested", Logos: ApprovalLogos{logo}})
+		if err == nil {
+			t.Errorf("CreateApprovalRequestWithOptions with logo %+v returned nil error", logo)
+		}
+	}
+}
+
+func TestCreateApprovalRequestOrderedDetails(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var sent string
+	httpmock.RegisterResponder("POST", "https://api.authy.com/onetouch/json/users/12345/approval_requests",
+		func(req *http.Request) (*http.Response, error) {
+			b, _ := ioutil.ReadAll(req.Body)
+			sent = string(b)
+			return httpmock.NewStringResponder(200, `{"approval_request": {"uuid": "abc"}, "success": true}`)(req)
+		})
+
+	_, err := client.CreateApprovalRequestWithOptions(12345, ApprovalRequestOptions{
+		Message: "Transfer requested",
+		OrderedDetails: OrderedApprovalDetails{
+			{Key: "To", Value: "Alice"},
+			{Key: "Amount", Value: "$10"},
+			{Key: "From", Value: "Savings"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateApprovalRequestWithOptions err = %v, expected nil", err)
+	}
+	expected := "message=Transfer+requested&details%5BTo%5D=Alice&details%5BAmount%5D=%2410&details%5BFrom%5D=Savings"
+	if sent != expected {
+		t.Errorf("CreateApprovalRequestWithOptions Body = %v, expected %v", sent, expected)
+	}
+
+	_, err = client.CreateApprovalRequestWithOptions(12345, ApprovalRequestOptions{
+		Message:        "Transfer requested",
+		Details:        ApprovalDetails{"To": "Alice"},
+		OrderedDetails: OrderedApprovalDetails{{Key: "Amount", Value: "$10"}},
+	})
+	if err == nil {
+		t.Errorf("CreateApprovalRequestWithOptions with Details and OrderedDetails returned nil error")
+	}
+}
+
+func TestGetApprovalRequestStatus(t *testing.T) {
+	setup()
+	defer teardown()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/onetouch/json/approval_requests/550e8400-e29b-41d4-a716-446655440000",
+		httpmock.NewStringResponder(200, `
+			{
+				"approval_request": {
+					"_authy_id": 12345,
+					"created_at": "2019-07-07T23:22:24Z",
+					"notified": false,
+					"processed_at": "2019-07-07T23:23:01Z",
+					"seconds_to_expire": 86400,
+					"status": "approved",
+					"updated_at": "2019-07-07T23:23:01Z",
+					"uuid": "550e8400-e29b-41d4-a716-446655440000"
+				},
+				"success": true
+			}`))
+
+	req, err := client.GetApprovalRequestStatus("550e8400-e29b-41d4-a716-446655440000")
+	if err != nil {
+		t.Fatalf("GetApprovalRequestStatus err = %v, expected nil", err)
+	}
+
+	if req.Status != ApprovalApproved {
+		t.Errorf("GetApprovalRequestStatus Status = %v, expected %v", req.Status, ApprovalApproved)
+	}
+	if req.AuthyID != 12345 || req.SecondsToExpire != 86400 {
+		t.Errorf("GetApprovalRequestStatus got %+v", req)
+	}
+	if req.ProcessedAt == nil || !req.ProcessedAt.Equal(time.Date(2019, 7, 7, 23, 23, 1, 0, time.UTC)) {
+		t.Errorf("GetApprovalRequestStatus ProcessedAt = %v", req.ProcessedAt)
+	}
+
+	if _, err := client.GetApprovalRequestStatus(""); err == nil {
+		t.Errorf("GetApprovalRequestStatus without a uuid returned nil error")
+	}
+}
+
+func TestWaitForApproval(t *testing.T) {
+	setup()
+	defer teardown()
+
+	polls := 0
+	httpmock.RegisterResponder("GET", "https://api.authy.com/onetouch/json/approval_requests/abc",
+		func(req *http.Request) (*http.Response, error) {
+			polls++
+			status := "pending"
+			if polls == 3 {
+				status = "denied"
+			}
+			return httpmock.NewStringResponse(200, `{"approval_request": {"uuid": "abc", "status": "`+status+`"}, "success": true}`), nil
+		})
+
+	opts := PollOptions{Interval: time.Millisecond, MaxInterval: time.Millisecond * 2}
+	req, err := client.WaitForApproval(context.Background(), "abc", opts)
+	if err != nil {
+		t.Fatalf("WaitForApproval err = %v, expected nil", err)
+	}
+	if req.Status != ApprovalDenied || polls != 3 {
+		t.Errorf("WaitForApproval = %v after %d polls, expected denied after 3", req.Status, polls)
+	}
+
+	// ctx is done while the second poll is in flight
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	polls = 0
+	httpmock.RegisterResponder("GET", "https://api.authy.com/onetouch/json/approval_requests/abc",
+		func(req *http.Request) (*http.Response, error) {
+			polls++
+			if polls == 2 {
+				cancel()
+				return nil, context.Canceled
+			}
+			return httpmock.NewStringResponse(200, `{"approval_request": {"uuid": "abc", "status": "pending"}, "success": true}`), nil
+		})
+	req, err = client.WaitForApproval(ctx, "abc", opts)
+	if !errors.Is(err, context.Canceled) || req == nil || req.Status != ApprovalPending {
+		t.Errorf("WaitForApproval = %+v, %v, expected pending and context.Canceled", req, err)
+	}
+}
+
+func TestOneTouchHealthy(t *testing.T) {
+	setup()
+	defer teardown()
+
+	notFound := `{"message": "Approval request not found", "success": false}`
+	cases := []struct {
+		app     string
+		status  int
+		body    string
+		healthy bool
+		err     bool
+	}{
+		{`{"app": {"onetouch_enabled": true}, "success": true}`, 404, notFound, true, false},
+		{`{"app": {"onetouch_enabled": true}, "success": true}`, 503, notFound, false, true},
+		{`{"app": {"onetouch_enabled": false}, "success": true}`, 404, notFound, false, true},
+		{`{"app": {"onetouch_enabled": true}, "success": true}`, 404, `<html><body>Not Found</body></html>`, false, true},
+		{`{"app": {"onetouch_enabled": true}, "success": true}`, 404, `Not Found`, false, true},
+	}
+
+	for i, c := range cases {
+		httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/app/details",
+			httpmock.NewStringResponder(200, c.app))
+		httpmock.RegisterResponder("GET", "https://api.authy.com/onetouch/json/approval_requests/"+healthCheckUUID,
+			httpmock.NewStringResponder(c.status, c.body))
+
+		healthy, err := client.OneTouchHealthy(context.Background())
+		if healthy != c.healthy || (err != nil) != c.err {
+			t.Errorf("%d: OneTouchHealthy = %v, %v, expected %v with error %v", i, healthy, err, c.healthy, c.err)
+		}
+	}
+}
+
+func TestVerifyCallbackSignature(t *testing.T) {
+	body := `{
+		"app_id": 1,
+		"approval_request": {
+			"expiration_timestamp": 1556832415,
+			"transaction": {
+				"created_at": "2019-07-07T23:22:24Z",
+				"customer_uuid": "c1",
+				"details": {"Location": "Sydney/AU", "Username": "bob smith"},
+				"hidden_details": {"ip": "10.0.0.1"},
+				"message": "Login requested"
+			}
+		},
+		"authy_id": 12345,
+		"callback_action": "approval_request_status",
+		"device_uuid": "abc",
+		"signature": "sig",
+		"status": "approved",
+		"uuid": "550e8400"
+	}`
+
+	// the signed data is spelled out, rather than built with the code under
+	// test, as the nonce, method, url and sorted url encoded params joined by
+	// pipes, with nested json flattened to key[sub]=value
+	postData := "1556832415|POST|https://example.com/authy/callback|" + strings.Join([]string{
+		"app_id=1",
+		"approval_request%5Bexpiration_timestamp%5D=1556832415",
+		"approval_request%5Btransaction%5D%5Bcreated_at%5D=2019-07-07T23%3A22%3A24Z",
+		"approval_request%5Btransaction%5D%5Bcustomer_uuid%5D=c1",
+		"approval_request%5Btransaction%5D%5Bdetails%5D%5BLocation%5D=Sydney%2FAU",
+		"approval_request%5Btransaction%5D%5Bdetails%5D%5BUsername%5D=bob+smith",
+		"approval_request%5Btransaction%5D%5Bhidden_details%5D%5Bip%5D=10.0.0.1",
+		"approval_request%5Btransaction%5D%5Bmessage%5D=Login+requested",
+		"authy_id=12345",
+		"callback_action=approval_request_status",
+		"device_uuid=abc",
+		"signature=sig",
+		"status=approved",
+		"uuid=550e8400",
+	}, "&")
+	// a GET callback has no body, its params are only signed in the url
+	getData := "1556832415|GET|https://example.com/authy/callback?authy_id=12345&status=denied&uuid=550e8400|"
+	sign := func(data string) string {
+		mac := hmac.New(sha256.New, []byte("verysecret"))
+		mac.Write([]byte(data))
+		return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+
+	cases := []struct {
+		method      string
+		target      string
+		contentType string
+		body        string
+		signature   string
+		apiKey      string
+		expected    bool
+	}{
+		{"POST", "https://example.com/authy/callback", "application/json", body, sign(postData), "verysecret", true},
+		{"POST", "https://example.com/authy/callback", "application/json", body, sign(postData), "wrongsecret", false},
+		{"POST", "https://example.com/authy/callback", "application/json", strings.Replace(body, "approved", "denied", 1),
+			sign(postData), "verysecret", false},
+		{"GET", "https://example.com/authy/callback?authy_id=12345&status=denied&uuid=550e8400", "", "",
+			sign(getData), "verysecret", true},
+	}
+
+	for i, c := range cases {
+		r := httptest.NewRequest(c.method, c.target, strings.NewReader(c.body))
+		r.Header.Set("Content-Type", c.contentType)
+		r.Header.Set("X-Authy-Signature", c.signature)
+		r.Header.Set("X-Authy-Signature-Nonce", "1556832415")
+
+		valid, err := VerifyCallbackSignature(r, c.apiKey)
+		if err != nil {
+			t.Fatalf("%d: VerifyCallbackSignature err = %v, expected nil", i, err)
+		}
+		if valid != c.expected {
+			t.Errorf("%d: VerifyCallbackSignature got %v, expected %v", i, valid, c.expected)
+		}
+
+		// the body should still be readable by the handler
+		b, _ := ioutil.ReadAll(r.Body)
+		if string(b) != c.body {
+			t.Errorf("%d: VerifyCallbackSignature did not restore the request body", i)
+		}
+	}
+
+	r := httptest.NewRequest("POST", "https://example.com/authy/callback", nil)
+	if _, err := VerifyCallbackSignature(r, "verysecret"); err == nil {
+		t.Errorf("VerifyCallbackSignature without signature headers returned nil error")
+	}
+}
+
+func TestParseApprovalCallback(t *testing.T) {
+	body := `{"authy_id": 12345, "device_uuid": "abc123", "callback_action": "approval_request_status",
+		"uuid": "996201c0-1111-2222-3333-123456789abc", "status": "approved", "signature": "c2lnbmF0dXJl",
+		"approval_request": {"transaction": {"message": "Login"}}}`
+	r := httptest.NewRequest("POST", "https://example.com/authy/callback", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+
+	cb, err := ParseApprovalCallback(r)
+	if err != nil {
+		t.Fatalf("ParseApprovalCallback err = %v, expected nil", err)
+	}
+	expected := ApprovalCallback{
+		AuthyID:        12345,
+		DeviceUUID:     "abc123",
+		CallbackAction: "approval_request_status",
+		UUID:           "996201c0-1111-2222-3333-123456789abc",
+		Status:         ApprovalApproved,
+		Signature:      "c2lnbmF0dXJl",
+	}
+	if *cb != expected {
+		t.Errorf("ParseApprovalCallback = %+v, expected %+v", cb, expected)
+	}
+
+	if b, _ := ioutil.ReadAll(r.Body); string(b) != body {
+		t.Errorf("ParseApprovalCallback didn't restore the request body")
+	}
+}
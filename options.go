@@ -0,0 +1,185 @@
+package authy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Option configures a Client when passed to NewClient
+type Option func(*Client)
+
+// WithHTTPClient sets the http client used to make requests to the Authy API,
+// so proxies, transports and connection pooling can be configured. The
+// client's transport and timeout are used as they are, unless WithTimeout is
+// also given. The default is an http.Client with a 20 second timeout using
+// http.DefaultTransport, which honours the HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		if hc != nil {
+			c.Client = hc
+		}
+	}
+}
+
+// WithTimeout sets the timeout for requests made by the client. It takes
+// precedence over the timeout of a client given to WithHTTPClient regardless
+// of the order the options are passed in, without modifying that client.
+// A deadline on the context passed to a WithContext method also applies, so
+// whichever of the two is shorter ends the request. Each retry gets the full
+// timeout but none are made after the context's deadline. How long each
+// request took is passed to hooks added with WithResponseHook
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// WithTLSConfig sets the TLS config of the transport used by the default
+// http client, which is otherwise a copy of http.DefaultTransport so still
+// uses any proxy set in the environment. It can't be combined with
+// WithHTTPClient, whose transport is never modified
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Client) {
+		c.tlsConfig = config
+	}
+}
+
+// WithInsecureSkipVerify turns off TLS certificate verification, for testing
+// against a local server with a self-signed certificate. Never use it in
+// production
+func WithInsecureSkipVerify() Option {
+	return WithTLSConfig(&tls.Config{InsecureSkipVerify: true})
+}
+
+// PoolConfig configures how connections to authy are kept open for reuse.
+// Zero values keep the http.DefaultTransport settings of 100 idle
+// connections, 2 per host and a 90 second idle timeout. As the client only
+// talks to one host, raise MaxIdleConnsPerHost to around the number of
+// requests made at once under load
+type PoolConfig struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+}
+
+func (p *PoolConfig) apply(t *http.Transport) {
+	if p.MaxIdleConns > 0 {
+		t.MaxIdleConns = p.MaxIdleConns
+	}
+	if p.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = p.MaxIdleConnsPerHost
+	}
+	if p.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = p.IdleConnTimeout
+	}
+}
+
+// WithConnectionPool configures connection reuse for the transport of the
+// default http client. It can't be combined with WithHTTPClient
+func WithConnectionPool(config PoolConfig) Option {
+	return func(c *Client) {
+		c.pool = &config
+	}
+}
+
+// defaultMaxResponseBytes is the largest response body read by default
+const defaultMaxResponseBytes = 4 << 20
+
+// WithMaxResponseBytes limits how much of a response body the client reads,
+// so a misbehaving server can't exhaust memory. Larger responses fail with an
+// error matching ErrResponseTooLarge. The default is 4MB
+func WithMaxResponseBytes(n int64) Option {
+	return func(c *Client) {
+		if n > 0 {
+			c.maxResponseBytes = n
+		}
+	}
+}
+
+// WithDefaultCountryCode sets the country code used when creating users
+// whose AuthyUser.CountryCode is empty, for apps that only serve one
+// country. A country code given in the AuthyUser is always used over it
+func WithDefaultCountryCode(countryCode string) Option {
+	return func(c *Client) {
+		cc := strings.TrimPrefix(countryCode, "+")
+		if len(cc) < 1 || len(cc) > 4 || !isDigits(cc) {
+			if c.optErr == nil {
+				c.optErr = fmt.Errorf("AUTHY: invalid default country code %q", countryCode)
+			}
+			return
+		}
+		c.defaultCountryCode = cc
+	}
+}
+
+// WithAutoForceSMS makes SendOTP and the SMS variants of it set force when
+// the user has the Authy app on a device, so authy sends the SMS rather than
+// ignoring it and expecting the token to come from the app. Working this out
+// takes a user status request before each SMS is sent, adding a round trip
+// to every call, and the SMS isn't sent if the status request fails
+func WithAutoForceSMS() Option {
+	return func(c *Client) {
+		c.autoForceSMS = true
+	}
+}
+
+// WithBaseURL points the client at a different Authy host, such as a
+// sandbox, a mock server in tests or a gateway in front of the API. If
+// rawURL has no path the /protected/ path is kept, otherwise its path is
+// used in place of /protected/, e.g. https://gw.example.com/authy/protected
+// sends requests to /authy/protected/json/. The OneTouch API is expected at
+// onetouch/json/ alongside the last segment of the path, /authy/onetouch/json/
+// in this example
+func WithBaseURL(rawURL string) Option {
+	return func(c *Client) {
+		u, err := url.Parse(rawURL)
+		if err == nil && (u.Scheme != "http" && u.Scheme != "https" || u.Host == "") {
+			err = fmt.Errorf("AUTHY: base url must be an absolute http(s) url")
+		}
+		if err != nil {
+			if c.optErr == nil {
+				c.optErr = err
+			}
+			return
+		}
+		c.host = u
+	}
+}
+
+// WithUserAgent adds the caller's product, e.g. "myapp/1.2", to the start of
+// the User-Agent header sent with every request
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// RequestHook is called before every request the client makes. Hooks are
+// called from every goroutine using the client so must be safe for
+// concurrent use
+type RequestHook func(req *http.Request)
+
+// ResponseHook is called after every request the client makes with the
+// response, any error and how long the request took. The response is nil
+// when the request failed and its body has already been read
+type ResponseHook func(resp *http.Response, err error, elapsed time.Duration)
+
+// WithRequestHook adds a hook called before each request, including retries
+func WithRequestHook(hook RequestHook) Option {
+	return func(c *Client) {
+		c.requestHooks = append(c.requestHooks, hook)
+	}
+}
+
+// WithResponseHook adds a hook called after each request, including retries
+// and requests that fail without a response
+func WithResponseHook(hook ResponseHook) Option {
+	return func(c *Client) {
+		c.responseHooks = append(c.responseHooks, hook)
+	}
+}
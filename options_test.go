@@ -0,0 +1,345 @@
+package authy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestWithHTTPClient(t *testing.T) {
+	hc := &http.Client{Timeout: time.Second}
+	c, _ := NewClient(App{ApiSecret: "verysecret"}, WithHTTPClient(hc))
+	if c.Client != hc {
+		t.Errorf("NewClient WithHTTPClient Client = %v, expected %v", c.Client, hc)
+	}
+
+	c, _ = NewClient(App{ApiSecret: "verysecret"}, WithHTTPClient(nil))
+	if c.Client == nil || c.Client.Timeout != time.Second*20 {
+		t.Errorf("NewClient WithHTTPClient(nil) Client = %v, expected default client", c.Client)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	c, _ := NewClient(App{ApiSecret: "verysecret"}, WithTimeout(time.Second*5))
+	if c.Client.Timeout != time.Second*5 {
+		t.Errorf("NewClient WithTimeout Timeout = %v, expected %v", c.Client.Timeout, time.Second*5)
+	}
+
+	// the timeout applies whichever order the options are given in
+	hc := &http.Client{Timeout: time.Minute}
+	for _, opts := range [][]Option{
+		{WithHTTPClient(hc), WithTimeout(time.Second * 5)},
+		{WithTimeout(time.Second * 5), WithHTTPClient(hc)},
+	} {
+		c, _ := NewClient(App{ApiSecret: "verysecret"}, opts...)
+		if c.Client.Timeout != time.Second*5 {
+			t.Errorf("NewClient WithTimeout Timeout = %v, expected %v", c.Client.Timeout, time.Second*5)
+		}
+		if hc.Timeout != time.Minute {
+			t.Errorf("NewClient WithTimeout modified the client given to WithHTTPClient")
+		}
+	}
+}
+
+func TestContextDeadlineShorterThanTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second * 5):
+		}
+	}))
+	defer server.Close()
+
+	c, _ := NewClient(App{ApiSecret: "verysecret"}, WithBaseURL(server.URL), WithTimeout(time.Second*20), WithRetry(3, time.Millisecond))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	start := time.Now()
+	err := c.Ping(ctx)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Ping with a 100ms deadline took %v", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrNetwork) {
+		t.Errorf("Ping err = %v, expected to match context.DeadlineExceeded and ErrNetwork", err)
+	}
+}
+
+func TestWithInsecureSkipVerify(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"message":"Token is valid.","token":"is valid","success":"true"}`))
+	}))
+	defer ts.Close()
+
+	c, err := NewClient(App{ApiSecret: "verysecret"}, WithBaseURL(ts.URL), WithInsecureSkipVerify())
+	if err != nil {
+		t.Fatalf("NewClient WithInsecureSkipVerify returned error: %v", err)
+	}
+	ok, err := c.CheckOTPToken(1, "123456")
+	if err != nil || !ok {
+		t.Errorf("CheckOTPToken with self-signed cert = %v, %v, expected true, nil", ok, err)
+	}
+
+	hc := &http.Client{}
+	_, err = NewClient(App{ApiSecret: "verysecret"}, WithHTTPClient(hc), WithInsecureSkipVerify())
+	if err == nil {
+		t.Errorf("NewClient WithHTTPClient and WithInsecureSkipVerify expected error")
+	}
+	if hc.Transport != nil {
+		t.Errorf("NewClient modified the transport of the client given to WithHTTPClient")
+	}
+}
+
+func TestDefaultTransportUsesProxyFromEnvironment(t *testing.T) {
+	c, _ := NewClient(App{ApiSecret: "verysecret"})
+	if c.Client.Transport != nil {
+		t.Errorf("NewClient Transport = %v, expected nil to use http.DefaultTransport", c.Client.Transport)
+	}
+
+	c, _ = NewClient(App{ApiSecret: "verysecret"}, WithInsecureSkipVerify())
+	transport, ok := c.Client.Transport.(*http.Transport)
+	if !ok || transport.Proxy == nil {
+		t.Errorf("NewClient WithInsecureSkipVerify Transport = %v, expected proxy from environment", c.Client.Transport)
+	}
+}
+
+func TestWithConnectionPool(t *testing.T) {
+	c, err := NewClient(App{ApiSecret: "verysecret"}, WithConnectionPool(PoolConfig{MaxIdleConnsPerHost: 50}))
+	if err != nil {
+		t.Fatalf("NewClient WithConnectionPool returned error: %v", err)
+	}
+	transport, ok := c.Client.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("NewClient WithConnectionPool Transport = %T, expected *http.Transport", c.Client.Transport)
+	}
+	if transport.MaxIdleConnsPerHost != 50 || transport.MaxIdleConns != 100 || transport.IdleConnTimeout != time.Second*90 {
+		t.Errorf("NewClient WithConnectionPool transport = %d, %d, %v, expected 100, 50, 90s",
+			transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.IdleConnTimeout)
+	}
+	if transport.Proxy == nil {
+		t.Errorf("NewClient WithConnectionPool transport doesn't use the proxy from the environment")
+	}
+
+	_, err = NewClient(App{ApiSecret: "verysecret"}, WithHTTPClient(&http.Client{}), WithConnectionPool(PoolConfig{}))
+	if err == nil {
+		t.Errorf("NewClient WithHTTPClient and WithConnectionPool expected error")
+	}
+}
+
+func TestClose(t *testing.T) {
+	c, _ := NewClient(App{ApiSecret: "verysecret"}, WithAppInfoCache(time.Minute))
+	httpmock.ActivateNonDefault(c.Client)
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/app/details",
+		httpmock.NewStringResponder(200, `{"success": true}`))
+
+	c.GetAppInfo()
+	if err := c.Close(); err != nil {
+		t.Errorf("Close err = %v, expected nil", err)
+	}
+	if _, err := c.GetAppInfo(); err != nil {
+		t.Errorf("GetAppInfo after Close err = %v, expected nil", err)
+	}
+	if n := httpmock.GetTotalCallCount(); n != 2 {
+		t.Errorf("requests made = %d, expected 2 as Close empties the cache", n)
+	}
+}
+
+func TestWithMaxResponseBytes(t *testing.T) {
+	c, _ := NewClient(App{ApiSecret: "verysecret"}, WithMaxResponseBytes(32))
+	httpmock.ActivateNonDefault(c.Client)
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/app/details",
+		httpmock.NewStringResponder(200, `{"success": true}`))
+	if _, err := c.GetAppInfo(); err != nil {
+		t.Errorf("GetAppInfo err = %v, expected nil", err)
+	}
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/app/details",
+		httpmock.NewStringResponder(200, `{"success": true, "message": "`+strings.Repeat("a", 64)+`"}`))
+	_, err := c.GetAppInfo()
+	if !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("GetAppInfo err = %v, expected to match ErrResponseTooLarge", err)
+	}
+}
+
+func TestWithDefaultCountryCode(t *testing.T) {
+	c, err := NewClient(App{ApiSecret: "verysecret"}, WithDefaultCountryCode("+61"))
+	if err != nil {
+		t.Fatalf("NewClient WithDefaultCountryCode err = %v, expected nil", err)
+	}
+	httpmock.ActivateNonDefault(c.Client)
+	defer httpmock.DeactivateAndReset()
+
+	var countryCode string
+	httpmock.RegisterResponder("POST", "https://api.authy.com/protected/json/users/new",
+		func(req *http.Request) (*http.Response, error) {
+			req.ParseForm()
+			countryCode = req.PostForm.Get("user[country_code]")
+			return httpmock.NewStringResponse(200, `{"user": {"id": 12345}, "success": true}`), nil
+		})
+
+	if _, err := c.CreateUser(AuthyUser{Cellphone: "412345678"}); err != nil || countryCode != "61" {
+		t.Errorf("CreateUser without country code = %v, sent %q, expected default 61", err, countryCode)
+	}
+	if _, err := c.CreateUser(AuthyUser{Cellphone: "4155551234", CountryCode: "1"}); err != nil || countryCode != "1" {
+		t.Errorf("CreateUser with country code = %v, sent %q, expected 1", err, countryCode)
+	}
+
+	if _, err := NewClient(App{ApiSecret: "verysecret"}, WithDefaultCountryCode("AU")); err == nil {
+		t.Errorf("NewClient WithDefaultCountryCode(AU) expected error")
+	}
+}
+
+func TestWithAutoForceSMS(t *testing.T) {
+	c, _ := NewClient(App{ApiSecret: "verysecret"}, WithAutoForceSMS())
+	httpmock.ActivateNonDefault(c.Client)
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/1/status",
+		httpmock.NewStringResponder(200, `{"status": {"devices": [{"os_type": "sms"}, {"os_type": "android"}]}, "success": true}`))
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/2/status",
+		httpmock.NewStringResponder(200, `{"status": {"devices": [{"os_type": "sms"}]}, "success": true}`))
+
+	var force string
+	httpmock.RegisterRegexpResponder("GET", regexp.MustCompile(`^https://api\.authy\.com/protected/json/sms/\d+`),
+		func(req *http.Request) (*http.Response, error) {
+			force = req.URL.Query().Get("force")
+			return httpmock.NewStringResponse(200, `{"success": true}`), nil
+		})
+
+	if _, err := c.SendOTP(1); err != nil || force != "true" {
+		t.Errorf("SendOTP to user with app = %v, force %q, expected true", err, force)
+	}
+	if _, err := c.SendOTP(2); err != nil || force != "" {
+		t.Errorf("SendOTP to user without app = %v, force %q, expected not set", err, force)
+	}
+	if n := httpmock.GetCallCountInfo()["GET https://api.authy.com/protected/json/users/1/status"]; n != 1 {
+		t.Errorf("status requests = %d, expected 1", n)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	var requests []string
+	var statuses []int
+	var errs []error
+
+	hc, _ := NewClient(App{ApiSecret: "verysecret"},
+		WithRequestHook(func(req *http.Request) {
+			requests = append(requests, req.URL.Path)
+		}),
+		WithResponseHook(func(resp *http.Response, err error, elapsed time.Duration) {
+			status := 0
+			if resp != nil {
+				status = resp.StatusCode
+			}
+			statuses = append(statuses, status)
+			errs = append(errs, err)
+		}),
+	)
+	httpmock.ActivateNonDefault(hc.Client)
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/app/details",
+		httpmock.NewStringResponder(200, `{"success": true}`))
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/app/stats",
+		httpmock.NewErrorResponder(errors.New("connection reset")))
+
+	hc.GetAppInfo()
+	hc.GetAppStats()
+
+	expectedRequests := []string{"/protected/json/app/details", "/protected/json/app/stats"}
+	if len(requests) != 2 || requests[0] != expectedRequests[0] || requests[1] != expectedRequests[1] {
+		t.Errorf("request hook saw %v, expected %v", requests, expectedRequests)
+	}
+	if len(statuses) != 2 || statuses[0] != 200 || statuses[1] != 0 {
+		t.Errorf("response hook saw statuses %v, expected [200 0]", statuses)
+	}
+	if len(errs) != 2 || errs[0] != nil || errs[1] == nil {
+		t.Errorf("response hook saw errors %v, expected [nil error]", errs)
+	}
+}
+
+func TestWithBaseURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/protected/json/app/details" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"app": {"name": "Sandbox"}, "success": true}`))
+	}))
+	defer server.Close()
+
+	c, _ := NewClient(App{ApiSecret: "verysecret"}, WithBaseURL(server.URL))
+	info, err := c.GetAppInfo()
+	if err != nil {
+		t.Fatalf("GetAppInfo err = %v, expected nil", err)
+	}
+	if info.App.Name != "Sandbox" {
+		t.Errorf("GetAppInfo App.Name = %v, expected Sandbox", info.App.Name)
+	}
+
+	c, _ = NewClient(App{ApiSecret: "verysecret", ApiFormat: "xml"}, WithBaseURL("https://sandbox.example.com"))
+	if c.baseURL.String() != "https://sandbox.example.com/protected/xml/" {
+		t.Errorf("NewClient WithBaseURL BaseURL = %v", c.baseURL)
+	}
+
+	var paths []string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Write([]byte(`{"approval_request": {"uuid": "abc"}, "success": true}`))
+	}))
+	defer gateway.Close()
+
+	c, err = NewClient(App{ApiSecret: "verysecret"}, WithBaseURL(gateway.URL+"/gateway/authy/protected/"))
+	if err != nil {
+		t.Fatalf("NewClient WithBaseURL with path err = %v, expected nil", err)
+	}
+	c.GetAppInfo()
+	c.CreateApprovalRequest(12345, "Login requested", nil)
+	expected := []string{"/gateway/authy/protected/json/app/details", "/gateway/authy/onetouch/json/users/12345/approval_requests"}
+	if strings.Join(paths, " ") != strings.Join(expected, " ") {
+		t.Errorf("WithBaseURL with path requested %v, expected %v", paths, expected)
+	}
+
+	c, _ = NewClient(App{ApiSecret: "verysecret"}, WithBaseURL("https://gw.example.com/authy-api"))
+	if c.baseURL.String() != "https://gw.example.com/authy-api/json/" {
+		t.Errorf("NewClient WithBaseURL BaseURL = %v", c.baseURL)
+	}
+
+	for _, invalid := range []string{"", "sandbox.example.com", "ftp://sandbox.example.com", "://bad"} {
+		if _, err := NewClient(App{ApiSecret: "verysecret"}, WithBaseURL(invalid)); err == nil {
+			t.Errorf("NewClient WithBaseURL(%q) returned nil error", invalid)
+		}
+	}
+}
+
+func TestWithUserAgent(t *testing.T) {
+	cases := []struct {
+		opts     []Option
+		expected string
+	}{
+		{nil, "authy-go-client/" + Version},
+		{[]Option{WithUserAgent("myapp/1.2")}, "myapp/1.2 authy-go-client/" + Version},
+	}
+
+	for _, c := range cases {
+		uc, _ := NewClient(App{ApiSecret: "verysecret"}, c.opts...)
+		req, err := uc.NewRequest("GET", "app/details", nil)
+		if err != nil {
+			t.Fatalf("NewRequest err = %v, expected nil", err)
+		}
+		if ua := req.Header.Get("User-Agent"); ua != c.expected {
+			t.Errorf("NewRequest User-Agent = %v, expected %v", ua, c.expected)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package authy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// phoneVerificationStart is the body posted to the verification start endpoint
+type phoneVerificationStart struct {
+	Via         Delivery `url:"via"`
+	CountryCode string   `url:"country_code"`
+	PhoneNumber string   `url:"phone_number"`
+	PhoneVerificationOptions
+}
+
+// PhoneVerificationOptions configures the code sent by
+// StartPhoneVerificationWithOptions
+type PhoneVerificationOptions struct {
+	// CodeLength is the number of digits in the code, from 4 to 10. Authy
+	// sends 4 digit codes if it isn't set
+	CodeLength int `url:"code_length,omitempty"`
+
+	// CustomCode is sent instead of a code generated by authy, for when the
+	// code is also delivered elsewhere or in tests. It must be 4 to 10
+	// digits. Authy rejects it unless custom codes have been enabled for the
+	// account by Twilio support
+	CustomCode string `url:"custom_code,omitempty"`
+}
+
+// StartPhoneVerification sends a verification code to the phone number
+// provided - via must be DeliverySMS, DeliveryCall, for a voice call, or
+// DeliveryWhatsApp. This does not require the phone number to belong to an
+// authy user
+// https://www.twilio.com/docs/authy/api/phone-verification
+func (c *Client) StartPhoneVerification(countryCode, phoneNumber string, via Delivery) (*ResponseMessage, error) {
+	return c.StartPhoneVerificationWithContext(context.Background(), countryCode, phoneNumber, via)
+}
+
+// StartPhoneVerificationWithContext is like StartPhoneVerification but uses
+// the provided context
+func (c *Client) StartPhoneVerificationWithContext(ctx context.Context, countryCode, phoneNumber string, via Delivery) (*ResponseMessage, error) {
+	return c.StartPhoneVerificationWithOptionsWithContext(ctx, countryCode, phoneNumber, via, PhoneVerificationOptions{})
+}
+
+// StartPhoneVerificationWithOptions is like StartPhoneVerification but sends
+// the code configured by opts
+func (c *Client) StartPhoneVerificationWithOptions(countryCode, phoneNumber string, via Delivery, opts PhoneVerificationOptions) (*ResponseMessage, error) {
+	return c.StartPhoneVerificationWithOptionsWithContext(context.Background(), countryCode, phoneNumber, via, opts)
+}
+
+// StartPhoneVerificationWithOptionsWithContext is like
+// StartPhoneVerificationWithOptions but uses the provided context
+func (c *Client) StartPhoneVerificationWithOptionsWithContext(ctx context.Context, countryCode, phoneNumber string, via Delivery, opts PhoneVerificationOptions) (*ResponseMessage, error) {
+	if countryCode == "" || phoneNumber == "" {
+		return nil, fmt.Errorf("AUTHY: country code or phone number not provided")
+	}
+	switch via {
+	case DeliverySMS, DeliveryCall, DeliveryWhatsApp:
+	default:
+		return nil, fmt.Errorf("AUTHY: unsupported phone verification delivery %q", via)
+	}
+
+	if opts.CodeLength != 0 && (opts.CodeLength < 4 || opts.CodeLength > 10) {
+		return nil, fmt.Errorf("AUTHY: code length must be between 4 and 10")
+	}
+	if opts.CustomCode != "" && (len(opts.CustomCode) < 4 || len(opts.CustomCode) > 10 || !isDigits(opts.CustomCode)) {
+		return nil, fmt.Errorf("AUTHY: custom code must be 4 to 10 digits")
+	}
+
+	body := phoneVerificationStart{
+		Via:                      via,
+		CountryCode:              countryCode,
+		PhoneNumber:              phoneNumber,
+		PhoneVerificationOptions: opts,
+	}
+
+	msg := new(ResponseMessage)
+	err := c.PostWithContext(ctx, "phones/verification/start", body, msg)
+	var apiErr *APIError
+	if via == DeliveryWhatsApp && errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest {
+		// authy rejects the channel with a 400 when the account can't use it
+		return msg, fmt.Errorf("AUTHY: whatsapp verification may not be enabled for this account: %w", err)
+	}
+	if err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+// phoneVerificationCheck are the query parameters for the verification check endpoint
+type phoneVerificationCheck struct {
+	CountryCode      string `url:"country_code"`
+	PhoneNumber      string `url:"phone_number"`
+	VerificationCode string `url:"verification_code"`
+}
+
+// CheckPhoneVerification checks the code sent by StartPhoneVerification
+// is valid for the phone number provided. An incorrect code is reported as
+// false with a nil error, errors are only returned when the check couldn't
+// be made
+func (c *Client) CheckPhoneVerification(countryCode, phoneNumber, code string) (bool, error) {
+	return c.CheckPhoneVerificationWithContext(context.Background(), countryCode, phoneNumber, code)
+}
+
+// CheckPhoneVerificationWithContext is like CheckPhoneVerification but uses
+// the provided context
+func (c *Client) CheckPhoneVerificationWithContext(ctx context.Context, countryCode, phoneNumber, code string) (bool, error) {
+	if countryCode == "" || phoneNumber == "" || code == "" {
+		return false, fmt.Errorf("AUTHY: country code, phone number or verification code not provided")
+	}
+
+	path, err := addOptions("phones/verification/check", phoneVerificationCheck{
+		CountryCode:      countryCode,
+		PhoneNumber:      phoneNumber,
+		VerificationCode: code,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	msg := struct {
+		Success flexBool `json:"success" xml:"success"`
+	}{}
+	err = c.GetWithContext(ctx, path, &msg)
+	if err != nil {
+		// a wrong code is a 401, like a wrong token for CheckOTPToken
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized && codeRejected(apiErr) {
+			return false, nil
+		}
+		return false, err
+	}
+	return bool(msg.Success), nil
+}
+
+// codeRejected reports whether a 401 from the verification check endpoint
+// was for an incorrect code, rather than e.g. an invalid api key
+func codeRejected(apiErr *APIError) bool {
+	switch apiErr.Code {
+	case "60022": // verification code is incorrect
+		return true
+	case "":
+		return strings.Contains(strings.ToLower(apiErr.Message), "code is incorrect")
+	}
+	return false
+}
+
+// PhoneInfo is the data returned by the phone info endpoint
+type PhoneInfo struct {
+	Type     string `json:"type" xml:"type"` // cellphone, landline, voip or unknown
+	Provider string `json:"provider" xml:"provider"`
+	Ported   bool   `json:"ported" xml:"ported"`
+	Message  string `json:"message" xml:"message"`
+	Success  bool   `json:"success" xml:"success"`
+}
+
+// phoneInfoQuery are the query parameters for the phone info endpoint
+type phoneInfoQuery struct {
+	CountryCode string `url:"country_code"`
+	PhoneNumber string `url:"phone_number"`
+}
+
+// GetPhoneInfo looks up the type and provider of the phone number provided
+// https://www.twilio.com/docs/authy/api/phone-intelligence
+func (c *Client) GetPhoneInfo(countryCode, phoneNumber string) (*PhoneInfo, error) {
+	return c.GetPhoneInfoWithContext(context.Background(), countryCode, phoneNumber)
+}
+
+// GetPhoneInfoWithContext is like GetPhoneInfo but uses the provided context
+func (c *Client) GetPhoneInfoWithContext(ctx context.Context, countryCode, phoneNumber string) (*PhoneInfo, error) {
+	if countryCode == "" || phoneNumber == "" {
+		return nil, fmt.Errorf("AUTHY: country code or phone number not provided")
+	}
+
+	path, err := addOptions("phones/info", phoneInfoQuery{
+		CountryCode: countryCode,
+		PhoneNumber: phoneNumber,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	info := new(PhoneInfo)
+	err = c.GetWithContext(ctx, path, info)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// twoDigitCountryCodes are the country calling codes that are 2 digits
+// long. Codes starting with 1 or 7 are a single digit and all others are 3
+// digits, as no code is a prefix of another
+var twoDigitCountryCodes = map[string]bool{
+	"20": true, "27": true, "30": true, "31": true, "32": true, "33": true,
+	"34": true, "36": true, "39": true, "40": true, "41": true, "43": true,
+	"44": true, "45": true, "46": true, "47": true, "48": true, "49": true,
+	"51": true, "52": true, "53": true, "54": true, "55": true, "56": true,
+	"57": true, "58": true, "60": true, "61": true, "62": true, "63": true,
+	"64": true, "65": true, "66": true, "81": true, "82": true, "84": true,
+	"86": true, "90": true, "91": true, "92": true, "93": true, "94": true,
+	"95": true, "98": true,
+}
+
+// ParseE164 splits a number in the international +14155551234 form into its
+// country calling code and national number. Separators such as spaces and
+// dashes are ignored. Only the length of the country code is worked out, it
+// isn't checked to be assigned and the national number isn't checked
+// against the country's numbering plan
+func ParseE164(e164 string) (countryCode, phoneNumber string, err error) {
+	number := stripPhoneSeparators(e164)
+	if !strings.HasPrefix(number, "+") {
+		return "", "", fmt.Errorf("AUTHY: phone number %q must start with + and the country code", e164)
+	}
+	number = number[1:]
+	if len(number) < 5 || len(number) > 15 || !isDigits(number) {
+		return "", "", fmt.Errorf("AUTHY: invalid phone number %q", e164)
+	}
+
+	n := 3
+	switch {
+	case number[0] == '1' || number[0] == '7':
+		n = 1
+	case twoDigitCountryCodes[number[:2]]:
+		n = 2
+	}
+	return number[:n], number[n:], nil
+}
+
+// CreateUserFromE164 is like CreateUser but takes the user's cellphone in
+// the international +14155551234 form, see ParseE164
+func (c *Client) CreateUserFromE164(e164 string) (int64, error) {
+	return c.CreateUserFromE164WithContext(context.Background(), e164)
+}
+
+// CreateUserFromE164WithContext is like CreateUserFromE164 but uses the
+// provided context
+func (c *Client) CreateUserFromE164WithContext(ctx context.Context, e164 string) (int64, error) {
+	countryCode, phoneNumber, err := ParseE164(e164)
+	if err != nil {
+		return 0, err
+	}
+	return c.CreateUserWithContext(ctx, AuthyUser{CountryCode: countryCode, Cellphone: phoneNumber})
+}
+
+// MaskPhone returns the phone number with all but its last digits replaced
+// by •, e.g. "+61 •••••5678", so it can be shown to the user a code was sent
+// to. At most 4 digits and never more than half the number are shown.
+// Numbers already masked by authy, such as SendOTP's "XXX-XXX-1234", are
+// masked the same way
+func MaskPhone(countryCode, cellphone string) string {
+	number := []rune(stripPhoneSeparators(cellphone))
+	if len(number) == 0 {
+		return ""
+	}
+
+	shown := len(number) / 2
+	if shown > 4 {
+		shown = 4
+	}
+	var b strings.Builder
+	if cc := strings.TrimPrefix(countryCode, "+"); cc != "" {
+		b.WriteString("+" + cc + " ")
+	}
+	for i, r := range number {
+		if i < len(number)-shown || r < '0' || r > '9' {
+			r = '•'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// validatePhone checks the country code and phone number look like they
+// could form an E.164 number, so obviously broken input is rejected without
+// a round trip to the API
+func validatePhone(countryCode, phoneNumber string) error {
+	cc := strings.TrimPrefix(countryCode, "+")
+	if len(cc) < 1 || len(cc) > 4 || !isDigits(cc) {
+		return fmt.Errorf("AUTHY: invalid country code %q", countryCode)
+	}
+
+	number := stripPhoneSeparators(phoneNumber)
+	// E.164 numbers are at most 15 digits including the country code
+	if len(number) < 4 || len(cc)+len(number) > 15 || !isDigits(number) {
+		return fmt.Errorf("AUTHY: invalid cellphone %q", phoneNumber)
+	}
+	return nil
+}
+
+// stripPhoneSeparators removes the spaces, dashes, dots and brackets commonly
+// used to format phone numbers
+func stripPhoneSeparators(phoneNumber string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')':
+			return -1
+		}
+		return r
+	}, phoneNumber)
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,288 @@
+package authy
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestStartPhoneVerification(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var sentBody string
+	httpmock.RegisterResponder("POST", "https://api.authy.com/protected/json/phones/verification/start",
+		func(req *http.Request) (*http.Response, error) {
+			b, _ := ioutil.ReadAll(req.Body)
+			sentBody = string(b)
+			return httpmock.NewStringResponse(200, `
+				{
+					"carrier": "AT&T Wireless",
+					"is_cellphone": true,
+					"message": "Text message sent to +1 111-111-1111.",
+					"success": true
+				}`), nil
+		})
+
+	msg, err := client.StartPhoneVerification("1", "111-111-1111", DeliverySMS)
+	if err != nil {
+		t.Fatalf("StartPhoneVerification err = %v, expected nil", err)
+	}
+
+	expectedBody := "country_code=1&phone_number=111-111-1111&via=sms"
+	if sentBody != expectedBody {
+		t.Errorf("StartPhoneVerification Body = %v, expected %v", sentBody, expectedBody)
+	}
+	if !msg.Success || !msg.IsCellphone || msg.Carrier != "AT&T Wireless" {
+		t.Errorf("StartPhoneVerification got %+v", msg)
+	}
+
+	cases := []struct {
+		countryCode string
+		phoneNumber string
+		via         Delivery
+	}{
+		{"", "111-111-1111", DeliverySMS},
+		{"1", "", DeliveryCall},
+		{"1", "111-111-1111", "email"},
+	}
+	for _, c := range cases {
+		if _, err := client.StartPhoneVerification(c.countryCode, c.phoneNumber, c.via); err == nil {
+			t.Errorf("StartPhoneVerification(%q, %q, %q) returned nil error", c.countryCode, c.phoneNumber, c.via)
+		}
+	}
+}
+
+func TestStartPhoneVerificationWithOptions(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var sentBody string
+	httpmock.RegisterResponder("POST", "https://api.authy.com/protected/json/phones/verification/start",
+		func(req *http.Request) (*http.Response, error) {
+			b, _ := ioutil.ReadAll(req.Body)
+			sentBody = string(b)
+			return httpmock.NewStringResponse(200, `{"success": true}`), nil
+		})
+
+	_, err := client.StartPhoneVerificationWithOptions("1", "111-111-1111", DeliverySMS, PhoneVerificationOptions{CustomCode: "1234"})
+	if err != nil {
+		t.Fatalf("StartPhoneVerificationWithOptions err = %v, expected nil", err)
+	}
+	expectedBody := "country_code=1&custom_code=1234&phone_number=111-111-1111&via=sms"
+	if sentBody != expectedBody {
+		t.Errorf("StartPhoneVerificationWithOptions Body = %v, expected %v", sentBody, expectedBody)
+	}
+
+	_, err = client.StartPhoneVerificationWithOptions("1", "111-111-1111", DeliveryCall, PhoneVerificationOptions{CodeLength: 8})
+	if err != nil {
+		t.Fatalf("StartPhoneVerificationWithOptions err = %v, expected nil", err)
+	}
+	expectedBody = "code_length=8&country_code=1&phone_number=111-111-1111&via=call"
+	if sentBody != expectedBody {
+		t.Errorf("StartPhoneVerificationWithOptions Body = %v, expected %v", sentBody, expectedBody)
+	}
+
+	_, err = client.StartPhoneVerificationWithOptions("1", "111-111-1111", DeliveryWhatsApp, PhoneVerificationOptions{})
+	if err != nil {
+		t.Fatalf("StartPhoneVerificationWithOptions err = %v, expected nil", err)
+	}
+	expectedBody = "country_code=1&phone_number=111-111-1111&via=whatsapp"
+	if sentBody != expectedBody {
+		t.Errorf("StartPhoneVerificationWithOptions Body = %v, expected %v", sentBody, expectedBody)
+	}
+
+	httpmock.RegisterResponder("POST", "https://api.authy.com/protected/json/phones/verification/start",
+		httpmock.NewStringResponder(400, `{"message": "Via is not valid", "error_code": "60001", "success": false}`))
+	_, err = client.StartPhoneVerification("1", "111-111-1111", DeliveryWhatsApp)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || !strings.Contains(err.Error(), "whatsapp verification may not be enabled") {
+		t.Errorf("StartPhoneVerification via whatsapp err = %v, expected it to say whatsapp isn't enabled", err)
+	}
+
+	for _, length := range []int{3, 11, -1} {
+		_, err := client.StartPhoneVerificationWithOptions("1", "111-111-1111", DeliverySMS, PhoneVerificationOptions{CodeLength: length})
+		if err == nil {
+			t.Errorf("StartPhoneVerificationWithOptions with code length %d returned nil error", length)
+		}
+	}
+
+	for _, code := range []string{"123", "12345678901", "12a4"} {
+		_, err := client.StartPhoneVerificationWithOptions("1", "111-111-1111", DeliverySMS, PhoneVerificationOptions{CustomCode: code})
+		if err == nil {
+			t.Errorf("StartPhoneVerificationWithOptions with custom code %q returned nil error", code)
+		}
+	}
+}
+
+func TestCheckPhoneVerification(t *testing.T) {
+	setup()
+	defer teardown()
+
+	url := "https://api.authy.com/protected/json/phones/verification/check"
+	cases := []struct {
+		responder httpmock.Responder
+		expected  bool
+		err       bool
+	}{
+		{httpmock.NewStringResponder(200, `{"message": "Verification code is correct.", "success": true}`), true, false},
+		{httpmock.NewStringResponder(200, `{"message": "Verification code is correct.", "success": "true"}`), true, false},
+		{httpmock.NewStringResponder(401, `{"message": "Verification code is incorrect", "success": false}`), false, false},
+		{httpmock.NewStringResponder(401, `{"message": "Verification code is incorrect", "error_code": "60022", "success": false}`), false, false},
+		{httpmock.NewStringResponder(401, `{"message": "Invalid API key", "error_code": "60001", "success": false}`), false, true},
+		{httpmock.NewStringResponder(500, ``), false, true},
+	}
+
+	for _, c := range cases {
+		var rawQuery string
+		httpmock.RegisterResponder("GET", url, func(req *http.Request) (*http.Response, error) {
+			rawQuery = req.URL.RawQuery
+			return c.responder(req)
+		})
+
+		valid, err := client.CheckPhoneVerification("1", "111-111-1111", "1234")
+		if valid != c.expected {
+			t.Errorf("CheckPhoneVerification got %v, expected %v", valid, c.expected)
+		}
+		if (err != nil) != c.err {
+			t.Errorf("CheckPhoneVerification err = %v, expected error %v", err, c.err)
+		}
+
+		expectedQuery := "country_code=1&phone_number=111-111-1111&verification_code=1234"
+		if rawQuery != expectedQuery {
+			t.Errorf("CheckPhoneVerification query = %v, expected %v", rawQuery, expectedQuery)
+		}
+	}
+
+	if _, err := client.CheckPhoneVerification("1", "111-111-1111", ""); err == nil {
+		t.Errorf("CheckPhoneVerification without a code returned nil error")
+	}
+}
+
+func TestGetPhoneInfo(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var rawQuery string
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/phones/info",
+		func(req *http.Request) (*http.Response, error) {
+			rawQuery = req.URL.RawQuery
+			return httpmock.NewStringResponse(200, `
+				{
+					"message": "Phone number information as of 2019-01-01 00:00:00 UTC",
+					"type": "voip",
+					"provider": "Google Voice",
+					"ported": true,
+					"success": true
+				}`), nil
+		})
+
+	info, err := client.GetPhoneInfo("1", "111-111-1111")
+	if err != nil {
+		t.Fatalf("GetPhoneInfo err = %v, expected nil", err)
+	}
+
+	expectedQuery := "country_code=1&phone_number=111-111-1111"
+	if rawQuery != expectedQuery {
+		t.Errorf("GetPhoneInfo query = %v, expected %v", rawQuery, expectedQuery)
+	}
+	if info.Type != "voip" || info.Provider != "Google Voice" || !info.Ported {
+		t.Errorf("GetPhoneInfo got %+v", info)
+	}
+}
+
+func TestValidatePhone(t *testing.T) {
+	cases := []struct {
+		countryCode string
+		phoneNumber string
+		valid       bool
+	}{
+		{"1", "111-111-1111", true},
+		{"+61", "(04) 1111 1111", true},
+		{"1", "111.111.1111", true},
+		{"1234", "11111", true},
+		{"12345", "111-111-1111", false},
+		{"", "111-111-1111", false},
+		{"a1", "111-111-1111", false},
+		{"1", "111-CALL-NOW", false},
+		{"1", "123", false},
+		{"61", "1111111111111111", false},
+	}
+
+	for _, c := range cases {
+		err := validatePhone(c.countryCode, c.phoneNumber)
+		if (err == nil) != c.valid {
+			t.Errorf("validatePhone(%q, %q) err = %v, expected valid %v", c.countryCode, c.phoneNumber, err, c.valid)
+		}
+	}
+}
+
+func TestParseE164(t *testing.T) {
+	cases := []struct {
+		in          string
+		countryCode string
+		phoneNumber string
+		err         bool
+	}{
+		{"+14155551234", "1", "4155551234", false},
+		{"+61 412 345 678", "61", "412345678", false},
+		{"+7 912 345-67-89", "7", "9123456789", false},
+		{"+353851234567", "353", "851234567", false},
+		{"+44 (20) 7946 0958", "44", "2079460958", false},
+		{"14155551234", "", "", true},
+		{"+1415abc1234", "", "", true},
+		{"+1234", "", "", true},
+	}
+
+	for _, c := range cases {
+		cc, number, err := ParseE164(c.in)
+		if (err != nil) != c.err || cc != c.countryCode || number != c.phoneNumber {
+			t.Errorf("ParseE164(%q) = %q, %q, %v, expected %q, %q, error %v", c.in, cc, number, err, c.countryCode, c.phoneNumber, c.err)
+		}
+	}
+}
+
+func TestCreateUserFromE164(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var sent url.Values
+	httpmock.RegisterResponder("POST", "https://api.authy.com/protected/json/users/new",
+		func(req *http.Request) (*http.Response, error) {
+			req.ParseForm()
+			sent = req.PostForm
+			return httpmock.NewStringResponse(200, `{"user": {"id": 12345}, "success": true}`), nil
+		})
+
+	id, err := client.CreateUserFromE164("+61412345678")
+	if err != nil || id != 12345 {
+		t.Fatalf("CreateUserFromE164 = %d, %v, expected 12345, nil", id, err)
+	}
+	if sent.Get("user[country_code]") != "61" || sent.Get("user[cellphone]") != "412345678" {
+		t.Errorf("CreateUserFromE164 sent %v, expected country code 61 and cellphone 412345678", sent)
+	}
+}
+
+func TestMaskPhone(t *testing.T) {
+	cases := []struct {
+		countryCode string
+		cellphone   string
+		expected    string
+	}{
+		{"61", "412 345 678", "+61 •••••5678"},
+		{"+1", "(415) 555-1234", "+1 ••••••1234"},
+		{"1", "XXX-XXX-1234", "+1 ••••••1234"},
+		{"", "12345", "•••45"},
+		{"44", "", ""},
+	}
+	for _, c := range cases {
+		if masked := MaskPhone(c.countryCode, c.cellphone); masked != c.expected {
+			t.Errorf("MaskPhone(%q, %q) = %q, expected %q", c.countryCode, c.cellphone, masked, c.expected)
+		}
+	}
+}
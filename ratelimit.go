@@ -0,0 +1,119 @@
+package authy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// limiter is a token bucket that refills at rate tokens per second up to
+// burst tokens
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// WithRateLimit limits the client to requestsPerSecond requests, allowing
+// bursts of up to burst requests. Requests wait for capacity before being
+// sent, or until their context is done
+func WithRateLimit(requestsPerSecond float64, burst int) Option {
+	return func(c *Client) {
+		if requestsPerSecond <= 0 {
+			c.limiter = nil
+			return
+		}
+		if burst < 1 {
+			burst = 1
+		}
+		c.limiter = &limiter{
+			rate:   requestsPerSecond,
+			burst:  float64(burst),
+			tokens: float64(burst),
+		}
+	}
+}
+
+// wait blocks until a token is available or ctx is done
+func (l *limiter) wait(ctx context.Context) error {
+	d := l.reserve()
+	if d == 0 {
+		return nil
+	}
+
+	if err := sleepContext(ctx, d); err != nil {
+		// give back the token so cancelled requests don't slow others down
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// reserve takes a token from the bucket and returns how long to wait
+// before it can be used
+func (l *limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// RateLimitInfo is the rate limit authy reported on a response. Limit and
+// Remaining are -1 when the response didn't include them
+type RateLimitInfo struct {
+	Limit      int           // X-RateLimit-Limit
+	Remaining  int           // X-RateLimit-Remaining
+	RetryAfter time.Duration // Retry-After, 0 if not set
+}
+
+// parseRateLimit reads the rate limit headers from resp
+func parseRateLimit(resp *http.Response) RateLimitInfo {
+	info := RateLimitInfo{Limit: -1, Remaining: -1}
+	if n, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit")); err == nil {
+		info.Limit = n
+	}
+	if n, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+		info.Remaining = n
+	}
+	if d, ok := retryAfter(resp); ok {
+		info.RetryAfter = d
+	}
+	return info
+}
+
+// RateLimit returns the rate limit reported on the last response the client
+// received, from any method. Use ResponseMessage.RateLimit to get the rate
+// limit of a particular response when the client is shared
+func (c *Client) RateLimit() RateLimitInfo {
+	c.rateLimitMu.Lock()
+	defer c.rateLimitMu.Unlock()
+	if c.rateLimit == nil {
+		return RateLimitInfo{Limit: -1, Remaining: -1}
+	}
+	return *c.rateLimit
+}
+
+func (c *Client) setRateLimit(info RateLimitInfo) {
+	c.rateLimitMu.Lock()
+	c.rateLimit = &info
+	c.rateLimitMu.Unlock()
+}
@@ -0,0 +1,83 @@
+package authy
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestWithRateLimit(t *testing.T) {
+	rc, _ := NewClient(App{ApiSecret: "verysecret"}, WithRateLimit(50, 2))
+	httpmock.ActivateNonDefault(rc.Client)
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/app/details",
+		httpmock.NewStringResponder(200, `{"success": true}`))
+
+	// the burst goes straight through then requests are spaced at 20ms
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := rc.GetAppInfo(); err != nil {
+			t.Fatalf("GetAppInfo err = %v, expected nil", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*55 {
+		t.Errorf("5 requests took %v, expected at least 60ms at 50 requests per second", elapsed)
+	}
+}
+
+func TestWithRateLimitContextCancelled(t *testing.T) {
+	rc, _ := NewClient(App{ApiSecret: "verysecret"}, WithRateLimit(0.1, 1))
+	httpmock.ActivateNonDefault(rc.Client)
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/app/details",
+		httpmock.NewStringResponder(200, `{"success": true}`))
+
+	if _, err := rc.GetAppInfo(); err != nil {
+		t.Fatalf("GetAppInfo err = %v, expected nil", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	if _, err := rc.GetAppInfoWithContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("GetAppInfoWithContext err = %v, expected %v", err, context.DeadlineExceeded)
+	}
+	if n := httpmock.GetTotalCallCount(); n != 1 {
+		t.Errorf("made %d requests, expected the rate limited request not to be sent", n)
+	}
+}
+
+func TestRateLimitInfo(t *testing.T) {
+	setup()
+	defer teardown()
+
+	if info := client.RateLimit(); info.Limit != -1 || info.Remaining != -1 {
+		t.Errorf("RateLimit before any request = %+v, expected unknown", info)
+	}
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/users/12345/status",
+		func(req *http.Request) (*http.Response, error) {
+			resp := httpmock.NewStringResponse(200, `{"success": true}`)
+			resp.Header.Set("X-RateLimit-Limit", "100")
+			resp.Header.Set("X-RateLimit-Remaining", "42")
+			resp.Header.Set("Retry-After", "3")
+			return resp, nil
+		})
+
+	msg, err := client.UserStatus(12345)
+	if err != nil {
+		t.Fatalf("UserStatus err = %v, expected nil", err)
+	}
+	expected := RateLimitInfo{Limit: 100, Remaining: 42, RetryAfter: time.Second * 3}
+	if msg.RateLimit != expected {
+		t.Errorf("ResponseMessage.RateLimit = %+v, expected %+v", msg.RateLimit, expected)
+	}
+	if info := client.RateLimit(); info != expected {
+		t.Errorf("RateLimit = %+v, expected %+v", info, expected)
+	}
+}
@@ -0,0 +1,70 @@
+package authy
+
+import (
+	"net/url"
+	"strings"
+)
+
+// RedactKey hides all but the first and last 2 characters of an API key so
+// it can be told apart from other keys in logs without leaking it. Keys too
+// short to partly show are hidden entirely
+func RedactKey(key string) string {
+	if len(key) < 12 {
+		return strings.Repeat("*", len(key))
+	}
+	return key[:2] + strings.Repeat("*", len(key)-4) + key[len(key)-2:]
+}
+
+// redactPath hides the token in verify paths, e.g. /verify/123456/12345, so
+// paths can be logged
+func redactPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if parts[i] == "verify" {
+			parts[i+1] = strings.Repeat("*", len(parts[i+1]))
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// redactValues hides an api_key parameter, which older authy examples pass
+// the key in, and the phone verification codes, reporting whether any were
+// found
+func redactValues(v url.Values) bool {
+	found := false
+	for name, values := range v {
+		for i, value := range values {
+			switch name {
+			case "api_key":
+				values[i] = RedactKey(value)
+			case "verification_code", "custom_code":
+				values[i] = strings.Repeat("*", len(value))
+			default:
+				continue
+			}
+			found = true
+		}
+	}
+	return found
+}
+
+// redactURL hides the token in verify paths and the secret query parameters
+func redactURL(u *url.URL) string {
+	r := *u
+	r.Path = redactPath(u.Path)
+	r.RawPath = ""
+	q := u.Query()
+	if redactValues(q) {
+		r.RawQuery = q.Encode()
+	}
+	return r.String()
+}
+
+// redactBody hides the secret form values
+func redactBody(body []byte) string {
+	form, err := url.ParseQuery(string(body))
+	if err != nil || !redactValues(form) {
+		return string(body)
+	}
+	return form.Encode()
+}
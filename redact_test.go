@@ -0,0 +1,83 @@
+package authy
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestRedactKey(t *testing.T) {
+	cases := []struct {
+		key      string
+		expected string
+	}{
+		{"abcdefghijklmnopqrstuvwxyz123456", "ab****************************56"},
+		{"verysecret", "**********"},
+		{"", ""},
+	}
+	for _, c := range cases {
+		if got := RedactKey(c.key); got != c.expected {
+			t.Errorf("RedactKey(%q) = %q, expected %q", c.key, got, c.expected)
+		}
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	u, _ := url.Parse("https://api.authy.com/protected/json/verify/123456/12345?api_key=abcdefghijklmnopqrstuvwxyz123456&force=true")
+	got := redactURL(u)
+	if strings.Contains(got, "123456/") || strings.Contains(got, "abcdefghijklmnopqrstuvwxyz") || !strings.Contains(got, "force=true") {
+		t.Errorf("redactURL = %v, expected token and api_key redacted", got)
+	}
+}
+
+func TestSecretNotPrinted(t *testing.T) {
+	secret := "abcdefghijklmnopqrstuvwxyz123456"
+	app := App{ApiSecret: secret}
+	c, _ := NewClient(app)
+
+	for _, s := range []string{
+		fmt.Sprintf("%v", app),
+		fmt.Sprintf("%+v", app),
+		fmt.Sprintf("%#v", app),
+		fmt.Sprintf("%v", c),
+		fmt.Sprint(c),
+		fmt.Sprintf("%#v", c),
+	} {
+		if strings.Contains(s, secret) {
+			t.Errorf("formatted output contains the api secret: %s", s)
+		}
+	}
+}
+
+func TestNetworkErrorRedactsToken(t *testing.T) {
+	setup()
+	defer teardown()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/verify/987654/12345",
+		httpmock.NewErrorResponder(errors.New("connection reset")))
+
+	_, err := client.CheckOTPToken(12345, "987654")
+	if err == nil || strings.Contains(err.Error(), "987654") {
+		t.Errorf("CheckOTPToken err = %v, expected error without the token", err)
+	}
+	if !errors.Is(err, ErrNetwork) {
+		t.Errorf("CheckOTPToken err = %v, expected to match ErrNetwork", err)
+	}
+}
+
+func TestNetworkErrorRedactsVerificationCode(t *testing.T) {
+	setup()
+	defer teardown()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/phones/verification/check",
+		httpmock.NewErrorResponder(errors.New("connection reset")))
+
+	_, err := client.CheckPhoneVerification("1", "111-111-1111", "987123")
+	if err == nil || strings.Contains(err.Error(), "987123") {
+		t.Errorf("CheckPhoneVerification err = %v, expected error without the code", err)
+	}
+}
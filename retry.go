@@ -0,0 +1,174 @@
+package authy
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryPolicy controls how failed requests are retried
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration // 0 uses defaultMaxRetryDelay
+	posts       bool          // retry POSTs on server errors as well as rate limiting
+}
+
+// defaultMaxRetryDelay is the longest backoff between retries by default
+const defaultMaxRetryDelay = 30 * time.Second
+
+// WithRetry retries requests that are rate limited (429) or fail with a 5xx
+// status up to maxAttempts times in total, backing off exponentially from
+// baseDelay with jitter, to at most 30 seconds unless WithMaxRetryDelay is
+// given. A Retry-After header on the response is respected, but one longer
+// than the max delay isn't waited for, the *APIError is returned with its
+// RetryAfter set instead. POST requests are only retried when rate limited, since the request was
+// not processed, unless WithRetryPosts is also given
+func WithRetry(maxAttempts int, baseDelay time.Duration) Option {
+	return func(c *Client) {
+		if c.retry == nil {
+			c.retry = &retryPolicy{}
+		}
+		c.retry.maxAttempts = maxAttempts
+		c.retry.baseDelay = baseDelay
+	}
+}
+
+// WithMaxRetryDelay caps the backoff between retries made by WithRetry. A
+// response with a longer Retry-After isn't retried
+func WithMaxRetryDelay(d time.Duration) Option {
+	return func(c *Client) {
+		if c.retry == nil {
+			c.retry = &retryPolicy{}
+		}
+		c.retry.maxDelay = d
+	}
+}
+
+// WithRetryPosts allows POST requests to be retried on 5xx responses when
+// used with WithRetry. Only use this if creating duplicate resources, such as
+// users, is acceptable or handled by the caller
+func WithRetryPosts() Option {
+	return func(c *Client) {
+		if c.retry == nil {
+			c.retry = &retryPolicy{}
+		}
+		c.retry.posts = true
+	}
+}
+
+// shouldRetry reports whether a request that received the status code on
+// the given attempt should be tried again
+func (p *retryPolicy) shouldRetry(method string, statusCode, attempt int) bool {
+	if attempt >= p.maxAttempts {
+		return false
+	}
+
+	if statusCode == http.StatusTooManyRequests {
+		return true
+	}
+
+	if statusCode >= 500 && statusCode <= 599 {
+		return method != http.MethodPost || p.posts
+	}
+	return false
+}
+
+// max returns the longest the policy will wait between attempts
+func (p *retryPolicy) max() time.Duration {
+	if p.maxDelay <= 0 {
+		return defaultMaxRetryDelay
+	}
+	return p.maxDelay
+}
+
+// delay returns how long to wait before the next attempt
+func (p *retryPolicy) delay(attempt int, resp *http.Response) time.Duration {
+	if d, ok := retryAfter(resp); ok {
+		return d
+	}
+
+	max := p.max()
+	// doubled one step at a time so large attempts can't overflow
+	d := p.baseDelay
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return jitter(d)
+}
+
+// jitter returns a random duration between half and all of d, so clients
+// backing off at the same time spread out
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// retryAfter parses the Retry-After header, which is either a number of
+// seconds or an http date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// poll calls check until it reports done, waiting the duration returned by
+// wait between calls. The error from check is returned if it fails, unless
+// ctx is done, in which case ctx's error is returned whether ctx finished
+// during a check or between them, so callers can return the last result
+// they saw along with it
+func poll(ctx context.Context, wait func() time.Duration, check func() (bool, error)) error {
+	for {
+		done, err := check()
+		if ctx.Err() != nil && (err != nil || !done) {
+			return ctx.Err()
+		}
+		if err != nil || done {
+			return err
+		}
+
+		if err := sleepContext(ctx, wait()); err != nil {
+			return err
+		}
+	}
+}
+
+// every returns a wait for poll of d between each call
+func every(d time.Duration) func() time.Duration {
+	return func() time.Duration { return d }
+}
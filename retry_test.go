@@ -0,0 +1,158 @@
+package authy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestWithRetry(t *testing.T) {
+	cases := []struct {
+		method    string
+		opts      []Option
+		responses []int
+		calls     int
+		success   bool
+	}{
+		{"GET", []Option{WithRetry(3, time.Millisecond)}, []int{500, 503, 200}, 3, true},
+		{"GET", []Option{WithRetry(2, time.Millisecond)}, []int{500, 503, 200}, 2, false},
+		{"GET", []Option{WithRetry(3, time.Millisecond)}, []int{400, 200}, 1, false},
+		{"GET", nil, []int{500, 200}, 1, false},
+		{"POST", []Option{WithRetry(3, time.Millisecond)}, []int{500, 200}, 1, false},
+		{"POST", []Option{WithRetry(3, time.Millisecond)}, []int{429, 200}, 2, true},
+		{"POST", []Option{WithRetry(3, time.Millisecond), WithRetryPosts()}, []int{500, 200}, 2, true},
+	}
+
+	for i, c := range cases {
+		rc, _ := NewClient(App{ApiSecret: "verysecret"}, c.opts...)
+		httpmock.ActivateNonDefault(rc.Client)
+
+		calls := 0
+		var bodies []string
+		httpmock.RegisterResponder(c.method, "https://api.authy.com/protected/json/some/thing",
+			func(req *http.Request) (*http.Response, error) {
+				b, _ := ioutil.ReadAll(req.Body)
+				bodies = append(bodies, string(b))
+				status := c.responses[calls]
+				calls++
+				return httpmock.NewStringResponse(status, `{"success": true}`), nil
+			})
+
+		var err error
+		body := struct {
+			Hello string `url:"hello"`
+		}{Hello: "World"}
+		if c.method == "GET" {
+			err = rc.Get("some/thing", new(ResponseMessage))
+		} else {
+			err = rc.Post("some/thing", body, new(ResponseMessage))
+		}
+
+		if calls != c.calls {
+			t.Errorf("%d: made %d requests, expected %d", i, calls, c.calls)
+		}
+		if (err == nil) != c.success {
+			t.Errorf("%d: err = %v, expected success %v", i, err, c.success)
+		}
+		if c.method == "POST" {
+			for _, b := range bodies {
+				if b != "hello=World" {
+					t.Errorf("%d: retried POST body = %v, expected hello=World", i, b)
+				}
+			}
+		}
+
+		httpmock.DeactivateAndReset()
+	}
+}
+
+func TestWithRetryContextCancelled(t *testing.T) {
+	rc, _ := NewClient(App{ApiSecret: "verysecret"}, WithRetry(5, time.Hour))
+	httpmock.ActivateNonDefault(rc.Client)
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/some/thing",
+		httpmock.NewStringResponder(503, ``))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	err := rc.GetWithContext(ctx, "some/thing", new(ResponseMessage))
+	if err != context.DeadlineExceeded {
+		t.Errorf("GetWithContext err = %v, expected %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRetryAfterOverMaxDelay(t *testing.T) {
+	cases := []struct {
+		opts       []Option
+		retryAfter string
+		calls      int
+	}{
+		{[]Option{WithRetry(3, time.Millisecond)}, "31", 1},
+		{[]Option{WithRetry(3, time.Millisecond), WithMaxRetryDelay(time.Second)}, "2", 1},
+		{[]Option{WithRetry(3, time.Millisecond), WithMaxRetryDelay(time.Second)}, "0", 3},
+	}
+
+	for i, c := range cases {
+		rc, _ := NewClient(App{ApiSecret: "verysecret"}, c.opts...)
+		httpmock.ActivateNonDefault(rc.Client)
+
+		calls := 0
+		httpmock.RegisterResponder("GET", "https://api.authy.com/protected/json/some/thing",
+			func(req *http.Request) (*http.Response, error) {
+				calls++
+				resp := httpmock.NewStringResponse(429, `{"message": "Too many requests", "success": false}`)
+				resp.Header.Set("Retry-After", c.retryAfter)
+				return resp, nil
+			})
+
+		err := rc.Get("some/thing", new(ResponseMessage))
+		if calls != c.calls {
+			t.Errorf("%d: made %d requests, expected %d", i, calls, c.calls)
+		}
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != 429 || apiErr.RetryAfter.String() != c.retryAfter+"s" {
+			t.Errorf("%d: err = %+v, expected *APIError with RetryAfter of %ss", i, err, c.retryAfter)
+		}
+
+		httpmock.DeactivateAndReset()
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := &retryPolicy{maxAttempts: 5, baseDelay: time.Second}
+
+	resp := &http.Response{Header: http.Header{}}
+	for attempt := 1; attempt <= 3; attempt++ {
+		max := time.Second << uint(attempt-1)
+		d := p.delay(attempt, resp)
+		if d < max/2 || d > max {
+			t.Errorf("delay(%d) = %v, expected between %v and %v", attempt, d, max/2, max)
+		}
+	}
+
+	// high attempts are capped rather than growing without bound or
+	// overflowing to 0
+	for _, attempt := range []int{6, 30, 35, 64, 1000} {
+		d := p.delay(attempt, resp)
+		if d < defaultMaxRetryDelay/2 || d > defaultMaxRetryDelay {
+			t.Errorf("delay(%d) = %v, expected between %v and %v", attempt, d, defaultMaxRetryDelay/2, defaultMaxRetryDelay)
+		}
+	}
+	c, _ := NewClient(App{ApiSecret: "verysecret"}, WithMaxRetryDelay(time.Second*2), WithRetry(5, time.Second))
+	p = c.retry
+	if d := p.delay(35, resp); d < time.Second || d > time.Second*2 {
+		t.Errorf("delay(35) with max delay = %v, expected between 1s and 2s", d)
+	}
+
+	resp.Header.Set("Retry-After", "7")
+	if d := p.delay(1, resp); d != time.Second*7 {
+		t.Errorf("delay with Retry-After = %v, expected %v", d, time.Second*7)
+	}
+}
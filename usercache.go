@@ -0,0 +1,80 @@
+package authy
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// userCache remembers the ids of recently created users by phone number
+type userCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]userCacheEntry
+}
+
+type userCacheEntry struct {
+	id      int64
+	expires time.Time
+}
+
+// WithCreatedUserCache guards against creating duplicate users when
+// CreateUser is retried, for example after a timeout. Authy returns the
+// existing user when the same phone number is registered again, but a
+// retry still costs a request and may resend the install link. With this
+// option the id of each user created is remembered by country code and
+// cellphone for ttl, and CreateUser returns it without calling authy
+func WithCreatedUserCache(ttl time.Duration) Option {
+	return func(c *Client) {
+		if ttl <= 0 {
+			c.createdUsers = nil
+			return
+		}
+		c.createdUsers = &userCache{
+			ttl:     ttl,
+			entries: make(map[string]userCacheEntry),
+		}
+	}
+}
+
+// userCacheKey normalizes the phone number so differently formatted copies
+// of the same number share an entry
+func userCacheKey(au AuthyUser) string {
+	return strings.TrimPrefix(au.CountryCode, "+") + ":" + stripPhoneSeparators(au.Cellphone)
+}
+
+func (uc *userCache) get(au AuthyUser) (int64, bool) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	key := userCacheKey(au)
+	e, ok := uc.entries[key]
+	if !ok {
+		return 0, false
+	}
+	if time.Now().After(e.expires) {
+		delete(uc.entries, key)
+		return 0, false
+	}
+	return e.id, true
+}
+
+func (uc *userCache) put(au AuthyUser, id int64) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	now := time.Now()
+	// drop expired entries so the cache doesn't grow without bound
+	for k, e := range uc.entries {
+		if now.After(e.expires) {
+			delete(uc.entries, k)
+		}
+	}
+	uc.entries[userCacheKey(au)] = userCacheEntry{id: id, expires: now.Add(uc.ttl)}
+}
+
+func (uc *userCache) clear() {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	uc.entries = make(map[string]userCacheEntry)
+}
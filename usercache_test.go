@@ -0,0 +1,48 @@
+package authy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func TestWithCreatedUserCache(t *testing.T) {
+	cc, _ := NewClient(App{ApiSecret: "verysecret"}, WithCreatedUserCache(time.Millisecond*50))
+	httpmock.ActivateNonDefault(cc.Client)
+	defer httpmock.DeactivateAndReset()
+
+	var keys []string
+	httpmock.RegisterResponder("POST", "https://api.authy.com/protected/json/users/new",
+		func(req *http.Request) (*http.Response, error) {
+			keys = append(keys, req.Header.Get("Idempotency-Key"))
+			return httpmock.NewStringResponse(200, `{"user": {"id": 12345}, "success": true}`), nil
+		})
+
+	au := AuthyUser{Cellphone: "111-111-111", CountryCode: "61", IdempotencyKey: "create-bob"}
+	for _, u := range []AuthyUser{au, {Cellphone: "111111111", CountryCode: "+61"}} {
+		id, err := cc.CreateUser(u)
+		if err != nil || id != 12345 {
+			t.Fatalf("CreateUser(%+v) = %v, %v expected 12345, nil", u, id, err)
+		}
+	}
+	if len(keys) != 1 {
+		t.Fatalf("CreateUser made %d requests, expected the second to be cached", len(keys))
+	}
+	if keys[0] != "create-bob" {
+		t.Errorf("CreateUser Idempotency-Key = %q, expected create-bob", keys[0])
+	}
+
+	// the install link is always sent even for a cached user
+	cc.ResendInstallLink(au)
+	if len(keys) != 2 {
+		t.Errorf("ResendInstallLink used the created user cache")
+	}
+
+	time.Sleep(time.Millisecond * 60)
+	cc.CreateUser(au)
+	if len(keys) != 3 {
+		t.Errorf("CreateUser used an expired cache entry")
+	}
+}